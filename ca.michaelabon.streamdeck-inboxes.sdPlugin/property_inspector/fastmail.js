@@ -9,10 +9,128 @@ $PI.onConnected((jsn) => {
 
     Utils.setFormValue(settings, form);
 
+    const modeSelect = document.getElementById('mailbox-mode-select');
+    const mailboxItem = document.getElementById('mailbox-item');
+    const mailboxSelect = document.getElementById('mailbox-select');
+    const mailboxStatus = document.getElementById('mailbox-status');
+    const mailboxStatusText = document.getElementById('mailbox-status-text');
+    const authMethodSelect = document.getElementById('auth-method-select');
+    const tokenFields = document.getElementById('token-fields');
+    const basicFields = document.getElementById('basic-fields');
+
+    // Either an API token or a username and password, depending on the server
+    function hasCredentials(values) {
+        if (values.authMethod === 'basic') {
+            return Boolean(values.username && values.password);
+        }
+        return Boolean(values.apiToken);
+    }
+
+    function showAuthFields() {
+        const basic = authMethodSelect.value === 'basic';
+        tokenFields.style.display = basic ? 'none' : '';
+        basicFields.style.display = basic ? '' : 'none';
+    }
+
+    if (!settings.authMethod) {
+        authMethodSelect.value = 'bearer';
+    }
+    showAuthFields();
+    authMethodSelect.addEventListener('change', showAuthFields);
+
+    let currentMailboxIds = [];
+    if (Array.isArray(settings.mailboxIds)) {
+        currentMailboxIds = settings.mailboxIds;
+    } else if (typeof settings.mailboxIds === 'string' && settings.mailboxIds) {
+        currentMailboxIds = [settings.mailboxIds];
+    }
+
+    // The form helpers don't know about multiple selection
+    function formSettings() {
+        const values = Utils.getFormValue(form);
+        if (!mailboxSelect.disabled) {
+            currentMailboxIds = Array.from(mailboxSelect.selectedOptions)
+                .map(option => option.value)
+                .filter(id => id);
+        }
+        values.mailboxIds = currentMailboxIds;
+        return values;
+    }
+
+    // Function to request mailboxes from plugin
+    function fetchMailboxes() {
+        const formValues = formSettings();
+        mailboxStatus.style.display = 'none';
+        mailboxSelect.disabled = true;
+        if (hasCredentials(formValues)) {
+            mailboxSelect.innerHTML = '<option value="">Loading...</option>';
+
+            $PI.sendToPlugin({
+                action: 'fetchMailboxes',
+                settings: formValues
+            });
+        } else {
+            mailboxSelect.innerHTML = '<option value="">Sign in first</option>';
+        }
+    }
+
+    function showMailboxes() {
+        const selected = modeSelect.value === 'selected';
+        mailboxItem.style.display = selected ? '' : 'none';
+        if (selected && mailboxSelect.disabled) {
+            fetchMailboxes();
+        }
+    }
+
+    if (!settings.mailboxMode) {
+        modeSelect.value = 'inbox';
+    }
+    showMailboxes();
+    modeSelect.addEventListener('change', showMailboxes);
+
+    // Listen for responses from the plugin
+    $PI.onSendToPropertyInspector('ca.michaelabon.streamdeck-inboxes.fastmail.action', (data) => {
+        const {payload} = data;
+
+        if (payload.action === 'fetchMailboxes') {
+            if (payload.error) {
+                mailboxSelect.disabled = true;
+                mailboxSelect.innerHTML = '<option value="">Failed to load</option>';
+                mailboxStatus.style.display = 'block';
+                mailboxStatusText.textContent = payload.error;
+            } else {
+                mailboxSelect.innerHTML = '';
+
+                payload.mailboxes.forEach(mailbox => {
+                    const option = document.createElement('option');
+                    option.value = mailbox.id;
+                    option.text = mailbox.name;
+                    if (currentMailboxIds.includes(mailbox.id)) {
+                        option.selected = true;
+                    }
+                    mailboxSelect.appendChild(option);
+                });
+
+                mailboxSelect.disabled = false;
+                mailboxStatus.style.display = 'none';
+            }
+        }
+    });
+
+    // Fetch mailboxes on server or credentials change (debounced)
+    const refetchMailboxes = Utils.debounce(500, () => {
+        if (modeSelect.value === 'selected') {
+            fetchMailboxes();
+        }
+    });
+    ['sessionUrl', 'authMethod', 'apiToken', 'username', 'password'].forEach(name => {
+        form.querySelector(`[name="${name}"]`).addEventListener('input', refetchMailboxes);
+    });
+
     form.addEventListener(
         'input',
         Utils.debounce(150, () => {
-            const value = Utils.getFormValue(form);
+            const value = formSettings();
             $PI.setSettings(value);
         })
     );
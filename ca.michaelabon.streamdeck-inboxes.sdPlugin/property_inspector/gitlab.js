@@ -9,10 +9,165 @@ $PI.onConnected((jsn) => {
 
         Utils.setFormValue(settings, form);
 
+        const multiSelects = {
+            categories: document.getElementById('categories-select'),
+            inboxZero: document.getElementById('inbox-zero-select'),
+        };
+
+        // Older keys and single selections are saved as a string
+        function asList(value) {
+            if (Array.isArray(value)) {
+                return value;
+            }
+            return typeof value === 'string' && value ? [value] : [];
+        }
+
+        Object.entries(multiSelects).forEach(([name, select]) => {
+            const selected = asList(settings[name]);
+            Array.from(select.options).forEach(option => {
+                option.selected = selected.includes(option.value);
+            });
+        });
+
+        // Groups and projects are listed by the plugin, so they are kept
+        // as saved until the lists arrive
+        const scopeSelects = {
+            groups: document.getElementById('groups-select'),
+            projects: document.getElementById('projects-select'),
+        };
+        const currentScopes = {
+            groups: asList(settings.groups),
+            projects: asList(settings.projects),
+        };
+        const scopesStatus = document.getElementById('scopes-status');
+        const scopesStatusText = document.getElementById('scopes-status-text');
+
+        // The form helpers don't know about multiple selection
+        function formSettings() {
+            const values = Utils.getFormValue(form);
+            Object.entries(multiSelects).forEach(([name, select]) => {
+                values[name] = Array.from(select.selectedOptions).map(option => option.value);
+            });
+            Object.entries(scopeSelects).forEach(([name, select]) => {
+                if (!select.disabled) {
+                    currentScopes[name] = Array.from(select.selectedOptions)
+                        .map(option => option.value)
+                        .filter(path => path);
+                }
+                values[name] = currentScopes[name];
+            });
+            return values;
+        }
+
+        function fetchScopes() {
+            const formValues = formSettings();
+            scopesStatus.style.display = 'none';
+            Object.values(scopeSelects).forEach(select => {
+                select.disabled = true;
+                select.innerHTML = formValues.server && formValues.personalAccessToken
+                    ? '<option value="">Loading...</option>'
+                    : '<option value="">Enter server and token first</option>';
+            });
+            if (formValues.server && formValues.personalAccessToken) {
+                $PI.sendToPlugin({
+                    action: 'fetchScopes',
+                    settings: formValues
+                });
+            }
+        }
+
+        const validateTokenButton = document.getElementById('validate-token-button');
+        const tokenStatusText = document.getElementById('token-status-text');
+
+        validateTokenButton.addEventListener('click', () => {
+            const formValues = formSettings();
+            if (!formValues.server || !formValues.personalAccessToken) {
+                tokenStatusText.textContent = 'Enter server and token first.';
+                return;
+            }
+            tokenStatusText.style.color = '';
+            tokenStatusText.textContent = 'Checking…';
+            $PI.sendToPlugin({
+                action: 'validateToken',
+                settings: formValues
+            });
+        });
+
+        function showToken(token) {
+            const lines = [`Signed in as ${token.name} (@${token.username}).`];
+            if (token.scopes) {
+                lines.push(`Scopes: ${token.scopes.join(', ')}.`);
+            }
+            if (token.expiresAt) {
+                lines.push(`Expires on ${token.expiresAt}.`);
+            }
+            if (token.warning) {
+                lines.push(`Warning: ${token.warning}.`);
+            }
+            tokenStatusText.style.color = token.warning ? '#ff6b6b' : '';
+            tokenStatusText.textContent = lines.join(' ');
+        }
+
+        $PI.onSendToPropertyInspector('ca.michaelabon.streamdeck-inboxes.gitlab.action', (data) => {
+            const {payload} = data;
+
+            if (payload.action === 'validateToken') {
+                if (payload.error) {
+                    tokenStatusText.style.color = '#ff6b6b';
+                    tokenStatusText.textContent = payload.error;
+                    return;
+                }
+                showToken(payload.token);
+                return;
+            }
+
+            if (payload.action === 'fetchScopes') {
+                if (payload.error) {
+                    Object.values(scopeSelects).forEach(select => {
+                        select.disabled = true;
+                        select.innerHTML = '<option value="">Failed to load</option>';
+                    });
+                    scopesStatus.style.display = 'block';
+                    scopesStatusText.textContent = payload.error;
+                    return;
+                }
+
+                Object.entries(scopeSelects).forEach(([name, select]) => {
+                    select.innerHTML = '';
+                    payload[name].forEach(scope => {
+                        const option = document.createElement('option');
+                        option.value = scope.path;
+                        option.text = scope.name;
+                        option.selected = currentScopes[name].includes(scope.path);
+                        select.appendChild(option);
+                    });
+                    select.disabled = false;
+                });
+                scopesStatus.style.display = 'none';
+            }
+        });
+
+        fetchScopes();
+
+        // Categories and inbox zero don't apply to pipelines
+        const modeSelect = document.getElementById('mode-select');
+        const inboxFields = document.getElementById('inbox-fields');
+        function showModeFields() {
+            inboxFields.style.display = modeSelect.value === 'pipelines' ? 'none' : '';
+        }
+        showModeFields();
+        modeSelect.addEventListener('change', showModeFields);
+
+        // Fetch groups and projects on server or token change (debounced)
+        const refetchScopes = Utils.debounce(500, fetchScopes);
+        ['server', 'personalAccessToken'].forEach(name => {
+            form.querySelector(`[name="${name}"]`).addEventListener('input', refetchScopes);
+        });
+
         form.addEventListener(
             'input',
             Utils.debounce(150, () => {
-                const value = Utils.getFormValue(form);
+                const value = formSettings();
                 $PI.setSettings(value);
             })
         );
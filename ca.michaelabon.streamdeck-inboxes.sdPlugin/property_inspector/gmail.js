@@ -14,21 +14,84 @@ $PI.onConnected((jsn) => {
     const labelSelect = document.getElementById('label-select');
     const labelStatus = document.getElementById('label-status');
     const labelStatusText = document.getElementById('label-status-text');
+    const authMethodSelect = document.getElementById('auth-method-select');
+    const passwordFields = document.getElementById('password-fields');
+    const oauthFields = document.getElementById('oauth-fields');
+    const authorizeButton = document.getElementById('authorize-button');
+    const authorizeStatusText = document.getElementById('authorize-status-text');
+    const refreshTokenInput = form.querySelector('input[name="refreshToken"]');
+
+    // Either the app password or a refresh token from signing in is enough
+    function hasCredentials(values) {
+        if (!values.username) {
+            return false;
+        }
+        if (values.authMethod === 'oauth') {
+            return Boolean(values.clientId && values.refreshToken);
+        }
+        return Boolean(values.password);
+    }
+
+    function showAuthFields() {
+        const oauth = authMethodSelect.value === 'oauth';
+        passwordFields.style.display = oauth ? 'none' : '';
+        oauthFields.style.display = oauth ? '' : 'none';
+        authorizeStatusText.textContent = refreshTokenInput.value ? 'Signed in.' : 'Not signed in.';
+    }
 
-    // Set initial label value if exists and credentials are present
-    if (settings.label && settings.username && settings.password) {
+    if (!settings.authMethod) {
+        authMethodSelect.value = 'password';
+    }
+    showAuthFields();
+    authMethodSelect.addEventListener('change', showAuthFields);
+
+    authorizeButton.addEventListener('click', () => {
+        authorizeStatusText.textContent = 'Waiting for you to sign in in the browser…';
+        $PI.sendToPlugin({
+            action: 'authorize',
+            settings: formSettings()
+        });
+    });
+
+    // Older keys saved a single label
+    let currentLabels = ['INBOX'];
+    if (Array.isArray(settings.labels) && settings.labels.length > 0) {
+        currentLabels = settings.labels;
+    } else if (typeof settings.labels === 'string' && settings.labels) {
+        currentLabels = [settings.labels];
+    } else if (settings.label) {
+        currentLabels = [settings.label];
+    }
+
+    // The form helpers don't know about multiple selection
+    function formSettings() {
+        const values = Utils.getFormValue(form);
+        delete values.label;
+        values.labels = Array.from(labelSelect.selectedOptions)
+            .map(option => option.value)
+            .filter(label => label);
+        if (values.labels.length === 0) {
+            values.labels = currentLabels;
+        }
+        return values;
+    }
+
+    // Set initial label values if credentials are present
+    if (hasCredentials(settings)) {
         labelSelect.innerHTML = '';
-        const option = document.createElement('option');
-        option.value = settings.label;
-        option.text = settings.label;
-        option.selected = true;
-        labelSelect.appendChild(option);
+        currentLabels.forEach(label => {
+            const option = document.createElement('option');
+            option.value = label;
+            option.text = label;
+            option.selected = true;
+            labelSelect.appendChild(option);
+        });
     }
 
     // Function to request labels from plugin
     function fetchLabels() {
         const formValues = Utils.getFormValue(form);
-        if (formValues.username && formValues.password) {
+        if (hasCredentials(formValues)) {
             labelSelect.disabled = true;
             labelSelect.innerHTML = '<option value="">Loading...</option>';
             labelStatus.style.display = 'none';
@@ -48,6 +111,18 @@ $PI.onConnected((jsn) => {
     $PI.onSendToPropertyInspector(ACTION_UUID, (data) => {
         const {payload} = data;
 
+        if (payload.action === 'authorize') {
+            if (payload.error) {
+                authorizeStatusText.textContent = 'Sign-in failed: ' + payload.error;
+            } else if (payload.refreshToken) {
+                refreshTokenInput.value = payload.refreshToken;
+                authorizeStatusText.textContent = 'Signed in.';
+                $PI.setSettings(formSettings());
+                fetchLabels();
+            }
+            return;
+        }
+
         if (payload.action === 'fetchLabels') {
             if (payload.error) {
                 labelSelect.disabled = true;
@@ -55,14 +130,13 @@ $PI.onConnected((jsn) => {
                 labelStatus.style.display = 'block';
                 labelStatusText.textContent = payload.error;
             } else {
-                const currentValue = settings.label || 'INBOX';
                 labelSelect.innerHTML = '';
 
                 payload.labels.forEach(label => {
                     const option = document.createElement('option');
                     option.value = label;
                     option.text = label;
-                    if (label === currentValue) {
+                    if (currentLabels.includes(label)) {
                         option.selected = true;
                     }
                     labelSelect.appendChild(option);
@@ -75,7 +149,7 @@ $PI.onConnected((jsn) => {
     });
 
     // Fetch labels on credential change (debounced)
-    const credentialInputs = form.querySelectorAll('input[name="username"], input[name="password"]');
+    const credentialInputs = form.querySelectorAll('input[name="username"], input[name="password"], input[name="clientId"]');
     credentialInputs.forEach(input => {
         input.addEventListener('input', Utils.debounce(500, () => {
             fetchLabels();
@@ -86,13 +160,14 @@ $PI.onConnected((jsn) => {
     form.addEventListener(
         'input',
         Utils.debounce(150, () => {
-            const value = Utils.getFormValue(form);
+            const value = formSettings();
+            currentLabels = value.labels;
             $PI.setSettings(value);
         })
     );
 
     // Fetch labels on initial load if credentials exist
-    if (settings.username && settings.password) {
+    if (hasCredentials(settings)) {
         fetchLabels();
     }
 
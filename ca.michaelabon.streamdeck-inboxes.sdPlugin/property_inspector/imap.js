@@ -0,0 +1,108 @@
+/// <reference path="./sdk/js/property-inspector.js" />
+/// <reference path="./sdk/js/utils.js" />
+
+const ACTION_UUID = 'ca.michaelabon.streamdeck-inboxes.imap.action';
+
+$PI.onConnected((jsn) => {
+    const form = document.querySelector('#property-inspector');
+    const {actionInfo, appInfo, connection, messageType, port, uuid} = jsn;
+    const {payload, context} = actionInfo;
+    const {settings} = payload;
+
+    Utils.setFormValue(settings, form);
+
+    const mailboxSelect = document.getElementById('mailbox-select');
+    const mailboxStatus = document.getElementById('mailbox-status');
+    const mailboxStatusText = document.getElementById('mailbox-status-text');
+
+    function hasCredentials(values) {
+        return Boolean(values.host && values.username && values.password);
+    }
+
+    // Set initial mailbox value if exists and credentials are present
+    if (settings.mailbox && hasCredentials(settings)) {
+        mailboxSelect.innerHTML = '';
+        const option = document.createElement('option');
+        option.value = settings.mailbox;
+        option.text = settings.mailbox;
+        option.selected = true;
+        mailboxSelect.appendChild(option);
+    }
+
+    // Function to request mailboxes from plugin
+    function fetchMailboxes() {
+        const formValues = Utils.getFormValue(form);
+        if (hasCredentials(formValues)) {
+            mailboxSelect.disabled = true;
+            mailboxSelect.innerHTML = '<option value="">Loading...</option>';
+            mailboxStatus.style.display = 'none';
+
+            $PI.sendToPlugin({
+                action: 'fetchMailboxes',
+                settings: formValues
+            });
+        } else {
+            mailboxSelect.disabled = true;
+            mailboxSelect.innerHTML = '<option value="">Enter credentials first</option>';
+            mailboxStatus.style.display = 'none';
+        }
+    }
+
+    // Listen for responses from the plugin
+    $PI.onSendToPropertyInspector(ACTION_UUID, (data) => {
+        const {payload} = data;
+
+        if (payload.action === 'fetchMailboxes') {
+            if (payload.error) {
+                mailboxSelect.disabled = true;
+                mailboxSelect.innerHTML = '<option value="">Failed to load</option>';
+                mailboxStatus.style.display = 'block';
+                mailboxStatusText.textContent = payload.error;
+            } else {
+                const currentValue = settings.mailbox || 'INBOX';
+                mailboxSelect.innerHTML = '';
+
+                payload.mailboxes.forEach(mailbox => {
+                    const option = document.createElement('option');
+                    option.value = mailbox;
+                    option.text = mailbox;
+                    if (mailbox === currentValue) {
+                        option.selected = true;
+                    }
+                    mailboxSelect.appendChild(option);
+                });
+
+                mailboxSelect.disabled = false;
+                mailboxStatus.style.display = 'none';
+            }
+        }
+    });
+
+    // Fetch mailboxes on connection change (debounced)
+    const connectionInputs = form.querySelectorAll(
+        'input[name="host"], input[name="port"], input[name="username"], input[name="password"], select[name="tlsMode"]'
+    );
+    connectionInputs.forEach(input => {
+        input.addEventListener('input', Utils.debounce(500, () => {
+            fetchMailboxes();
+        }));
+    });
+
+    // Standard form change handler
+    form.addEventListener(
+        'input',
+        Utils.debounce(150, () => {
+            const value = Utils.getFormValue(form);
+            $PI.setSettings(value);
+        })
+    );
+
+    // Fetch mailboxes on initial load if credentials exist
+    if (hasCredentials(settings)) {
+        fetchMailboxes();
+    }
+});
+
+$PI.onDidReceiveGlobalSettings(({payload}) => {
+    console.log('onDidReceiveGlobalSettings', payload);
+});
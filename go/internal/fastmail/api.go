@@ -1,28 +1,50 @@
 package fastmail
 
 import (
-	"bytes"
-	"encoding/json"
-	"errors"
+	"context"
 	"fmt"
-	"io"
-	"log"
 	"net/http"
 	"time"
 
-	"golang.org/x/exp/slices"
+	"ca.michaelabon.inboxes/internal/httpx"
+	"ca.michaelabon.inboxes/internal/inbox"
 )
 
 type Settings struct {
-	ApiToken string
+	// SessionUrl is the JMAP session resource of the server, DefaultSessionUrl if empty.
+	SessionUrl string
+	// AuthMethod is AuthMethodBearer (default) or AuthMethodBasic.
+	AuthMethod string
+	ApiToken   string
+	Username   string
+	Password   string
+	// WebmailUrl opens on press instead of the Fastmail web app.
+	WebmailUrl string
+	// MailboxMode is MailboxModeInbox (default), MailboxModeSelected or MailboxModeAll.
+	MailboxMode string
+	// MailboxIds are the mailboxes counted in MailboxModeSelected.
+	MailboxIds inbox.OneOrMany[string]
+	// CountMode is CountModeUnreadEmails (default), CountModeUnreadThreads or CountModeFlagged.
+	CountMode string
 }
 
-func FetchUnseenCount(settings Settings) (uint, error) {
-	if settings.ApiToken == "" {
-		return 0, errors.New("missing ApiToken")
+func FetchUnseenCount(ctx context.Context, sessions *sessionCache, settings Settings) (Result, error) {
+	server, err := settings.server()
+	if err != nil {
+		return Result{}, err
+	}
+
+	return getUnseenCount(ctx, sessions, settings, server)
+}
+
+// FetchMailboxes returns every mailbox of the account, for the property inspector.
+func FetchMailboxes(ctx context.Context, sessions *sessionCache, settings Settings) ([]Mailbox, error) {
+	server, err := settings.server()
+	if err != nil {
+		return nil, err
 	}
 
-	return getUnseenCount(settings)
+	return getMailboxes(ctx, sessions, server)
 }
 
 type MailboxGetResponse struct {
@@ -32,163 +54,104 @@ type MailboxGetResponse struct {
 }
 
 type Mailbox struct {
-	Id           string
-	Name         string
-	Role         string
-	SortOrder    uint
-	TotalEmails  uint
-	UnreadEmails uint
-}
-
-type SessionResponse struct {
-	PrimaryAccounts map[string]string
+	Id            string
+	ParentId      string
+	Name          string
+	Role          string
+	SortOrder     uint
+	TotalEmails   uint
+	UnreadEmails  uint
+	UnreadThreads uint
 }
 
-func makeRequest(url, method, bearer string, body io.Reader) ([]byte, error) {
-	client := &http.Client{}
-	req, err := http.NewRequest(method, url, body)
-	if err != nil {
-		return nil, fmt.Errorf("error while newing request: %w", err)
-	}
-
-	req.Header.Add("Authorization", "Bearer "+bearer)
-	if method == "POST" {
-		req.Header.Add("Content-Type", "application/json")
+func makeRequest(
+	ctx context.Context,
+	client *httpx.Client,
+	server server,
+	url, method string,
+	body []byte,
+) ([]byte, error) {
+	header := http.Header{}
+	header.Set("Authorization", server.Authorization)
+	if method == http.MethodPost {
+		header.Set("Content-Type", "application/json")
 	}
 
-	res, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("error while doing request: %w", err)
-	}
-
-	defer func(body io.ReadCloser) {
-		err := body.Close()
-		if err != nil {
-			log.Println("[fastmail]", "error while closing body:", err)
-		}
-	}(res.Body)
-
-	resBody, err := io.ReadAll(res.Body)
+	res, err := client.Do(ctx, method, url, header, body)
 	if err != nil {
-		return nil, fmt.Errorf("error while reading body: %w", err)
+		return nil, err
 	}
 
-	return resBody, nil
+	return res.Body, nil
 }
 
-func makeGetRequest(url, bearer string) ([]byte, error) {
-	return makeRequest(url, "GET", bearer, nil)
+func makeGetRequest(ctx context.Context, client *httpx.Client, server server, url string) ([]byte, error) {
+	return makeRequest(ctx, client, server, url, http.MethodGet, nil)
 }
 
-func makePostRequest(url string, bearer string, body io.Reader) ([]byte, error) {
-	return makeRequest(url, "POST", bearer, body)
+func makePostRequest(
+	ctx context.Context,
+	client *httpx.Client,
+	server server,
+	url string,
+	body []byte,
+) ([]byte, error) {
+	return makeRequest(ctx, client, server, url, http.MethodPost, body)
 }
 
-func getUnseenCount(settings Settings) (uint, error) {
-	sessionUrl := "https://api.fastmail.com/jmap/session"
-	rawSessionResponse, err := makeGetRequest(sessionUrl, settings.ApiToken)
+func getUnseenCount(ctx context.Context, sessions *sessionCache, settings Settings, server server) (Result, error) {
+	session, err := sessions.get(ctx, server)
 	if err != nil {
-		return 0, fmt.Errorf("error while getting session: %w", err)
+		return Result{}, err
 	}
 
-	sessionResponse := &SessionResponse{}
-	err = json.Unmarshal(rawSessionResponse, sessionResponse)
+	response, err := mailboxGet(ctx, session)
 	if err != nil {
-		return 0, fmt.Errorf("error while unmarshalling session response: %w", err)
-	}
-	accountId, ok := sessionResponse.PrimaryAccounts["urn:ietf:params:jmap:mail"]
-	if !ok {
-		return 0, fmt.Errorf(
-			"error while retrieving primary account %v",
-			sessionResponse.PrimaryAccounts,
-		)
+		return Result{}, err
 	}
 
-	log.Println("[fastmail]", "successfully got accountId", accountId)
-
-	apiUrl := "https://api.fastmail.com/jmap/api"
-	apiBody := []byte(fmt.Sprintf(`{
-		"using": ["urn:ietf:params:jmap:core", "urn:ietf:params:jmap:mail"],
-		"methodCalls": [[
-			"Mailbox/get",
-			{
-				"accountId": "%s",
-				"ids":       null
-			},
-			"0"
-		]]
-	}`, accountId))
-	rawApiResponse, err := makePostRequest(apiUrl, settings.ApiToken, bytes.NewBuffer(apiBody))
-	if err != nil {
-		return 0, fmt.Errorf("error while posting Mailbox/get request: %w", err)
-	}
-
-	apiResponse := &apiResponse{}
-	err = json.Unmarshal(rawApiResponse, apiResponse)
-	if err != nil {
-		return 0, fmt.Errorf("error while unmarshalling api response: %w", err)
-	}
-
-	invocation := apiResponse.MethodResponses[0]
-	mailboxes := invocation.Args.List
+	return count(ctx, settings, session, response.List)
+}
 
-	mailboxIdx := slices.IndexFunc(mailboxes, func(m Mailbox) bool {
-		return m.Role == "inbox"
-	})
-	if mailboxIdx == -1 {
-		return 0, fmt.Errorf("unable to find inbox in methodResponse %v", invocation)
+// count counts the mailboxes chosen by the settings the way the settings ask.
+// Unread counts come with the mailboxes; flagged emails need a query.
+func count(ctx context.Context, settings Settings, session *session, mailboxes []Mailbox) (Result, error) {
+	if settings.CountMode == CountModeFlagged {
+		return countFlagged(ctx, settings, session, mailboxes)
 	}
 
-	return mailboxes[mailboxIdx].UnreadEmails, nil
+	return countUnread(settings, mailboxes)
 }
 
-const RefreshInterval = time.Minute
+func getMailboxes(ctx context.Context, sessions *sessionCache, server server) ([]Mailbox, error) {
+	session, err := sessions.get(ctx, server)
+	if err != nil {
+		return nil, err
+	}
 
-type apiResponse struct {
-	MethodResponses []rawInvocation `json:"methodResponses"`
-}
+	response, err := mailboxGet(ctx, session)
+	if err != nil {
+		return nil, err
+	}
 
-type rawInvocation struct {
-	Name   string
-	Args   MailboxGetResponse
-	CallID string
+	return response.List, nil
 }
 
-func (i *rawInvocation) UnmarshalJSON(data []byte) error {
-	var methodName, callId string
-	var args MailboxGetResponse
-
-	// Slice so we can detect invalid size.
-	const correctSize = 3
-	triplet := make([]json.RawMessage, 0, correctSize)
-	if err := json.Unmarshal(data, &triplet); err != nil {
-		return fmt.Errorf("error while unmarshalling triplet: %w", err)
-	}
-	if len(triplet) != correctSize {
-		return fmt.Errorf(
-			"jmap: malformed Invocation object, need exactly 3 elements, got %d, %v",
-			len(triplet),
-			triplet,
-		)
-	}
-
-	if err := json.Unmarshal(triplet[0], &methodName); err != nil {
-		return err
-	}
-	if err := json.Unmarshal(triplet[2], &callId); err != nil {
-		return err
-	}
-	if err := json.Unmarshal(triplet[1], &args); err != nil {
-		return err
+// mailboxGet fetches every mailbox of the account, with the state to pass to Mailbox/changes.
+func mailboxGet(ctx context.Context, session *session) (MailboxGetResponse, error) {
+	var response MailboxGetResponse
+	err := call(ctx, session, methodCall{
+		Name: "Mailbox/get",
+		Args: map[string]interface{}{
+			"accountId": session.AccountId,
+			"ids":       nil,
+		},
+	}, &response)
+	if err != nil {
+		return MailboxGetResponse{}, fmt.Errorf("error while calling Mailbox/get: %w", err)
 	}
 
-	i.Name = methodName
-	i.CallID = callId
-	i.Args = args
-
-	return nil
+	return response, nil
 }
 
-func (i *rawInvocation) MarshalJSON() ([]byte, error) {
-	return json.Marshal([3]interface{}{i.Name, i.Args, i.CallID})
-}
+const RefreshInterval = time.Minute
@@ -0,0 +1,54 @@
+package fastmail
+
+import (
+	"fmt"
+
+	"ca.michaelabon.inboxes/internal/inbox"
+)
+
+// JMAP method error types (RFC 8620 §3.6.2 and RFC 8621) that we act on.
+const (
+	ErrorTypeAccountNotFound             = "accountNotFound"
+	ErrorTypeAccountNotSupportedByMethod = "accountNotSupportedByMethod"
+	ErrorTypeAccountReadOnly             = "accountReadOnly"
+	ErrorTypeForbidden                   = "forbidden"
+	ErrorTypeInvalidArguments            = "invalidArguments"
+	ErrorTypeServerFail                  = "serverFail"
+	ErrorTypeServerPartialFail           = "serverPartialFail"
+	ErrorTypeServerUnavailable           = "serverUnavailable"
+	ErrorTypeUnknownMethod               = "unknownMethod"
+	ErrorTypeCannotCalculateChanges      = "cannotCalculateChanges"
+)
+
+// MethodError is an "error" response to a JMAP method call.
+// The request as a whole succeeded, but this method failed.
+type MethodError struct {
+	Method      string `json:"-"` // The method that was called
+	Type        string `json:"type"`
+	Description string `json:"description"`
+}
+
+func (e *MethodError) Error() string {
+	if e.Description == "" {
+		return fmt.Sprintf("jmap: %s failed: %s", e.Method, e.Type)
+	}
+
+	return fmt.Sprintf("jmap: %s failed: %s: %s", e.Method, e.Type, e.Description)
+}
+
+// Category tells the key whether the credentials, the settings
+// or the server are to blame.
+func (e *MethodError) Category() inbox.ErrorCategory {
+	switch e.Type {
+	case ErrorTypeAccountNotFound, ErrorTypeAccountNotSupportedByMethod, ErrorTypeForbidden:
+		// The credentials can't see the account, or can't read its mail
+		return inbox.CategoryAuth
+	case ErrorTypeServerFail, ErrorTypeServerPartialFail, ErrorTypeServerUnavailable:
+		return inbox.CategoryUpstream
+	case ErrorTypeUnknownMethod:
+		// The server doesn't speak JMAP Mail, so the session URL is wrong
+		return inbox.CategoryConfig
+	default:
+		return inbox.CategoryUnknown
+	}
+}
@@ -0,0 +1,303 @@
+package fastmail
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// fakeAccountId is the mail account of the fake JMAP server.
+const fakeAccountId = "account-1"
+
+// fakeJMAP is a local JMAP server with a session resource, an API that
+// answers Mailbox/get, Mailbox/changes and Email/query, and an event source.
+type fakeJMAP struct {
+	*httptest.Server
+
+	// pushes is false to leave the eventSourceUrl out of the session.
+	pushes bool
+	// events are written to the event source as they are sent.
+	events chan string
+
+	mu sync.Mutex
+	// sessionState is the session state reported with each API response.
+	sessionState string
+	// sessionFetches counts the requests for the session resource.
+	sessionFetches int
+	mailboxes      []Mailbox
+	// state counts the changes to the mailboxes; updated are the
+	// mailboxes changed in each state, by the state they changed in.
+	state   int
+	updated map[int][]string
+	// flagged are the mailboxes of each flagged email.
+	flagged [][]string
+	// maxCalls is the server's maxCallsInRequest.
+	maxCalls int
+	// methods are the methods called, in order, one request per entry.
+	methods [][]string
+	// streams are the query strings the event source was opened with.
+	streams []string
+}
+
+func newFakeJMAP(t *testing.T, mailboxes ...Mailbox) *fakeJMAP {
+	t.Helper()
+
+	f := &fakeJMAP{
+		pushes:       true,
+		events:       make(chan string),
+		sessionState: "session-1",
+		mailboxes:    mailboxes,
+		updated:      map[int][]string{},
+		maxCalls:     16,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /jmap/session", f.serveSession)
+	mux.HandleFunc("POST /jmap/api", f.serveAPI)
+	mux.HandleFunc("GET /jmap/events", f.serveEvents)
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+
+	return f
+}
+
+// settings are key settings that point at the fake server.
+func (f *fakeJMAP) settings() Settings {
+	return Settings{SessionUrl: f.URL + "/jmap/session", ApiToken: "the-token"}
+}
+
+func (f *fakeJMAP) serveSession(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer the-token" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+		return
+	}
+
+	f.mu.Lock()
+	f.sessionFetches++
+	session := map[string]interface{}{
+		"primaryAccounts": map[string]string{capabilityMail: fakeAccountId},
+		"apiUrl":          "/jmap/api",
+		"state":           f.sessionState,
+		"capabilities": map[string]interface{}{
+			capabilityCore: map[string]int{"maxCallsInRequest": f.maxCalls},
+			capabilityMail: map[string]interface{}{},
+		},
+	}
+	f.mu.Unlock()
+	if f.pushes {
+		session["eventSourceUrl"] = "/jmap/events?types={types}&closeafter={closeafter}&ping={ping}"
+	}
+	writeJSON(w, session)
+}
+
+func (f *fakeJMAP) serveAPI(w http.ResponseWriter, r *http.Request) {
+	var request apiRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(request.MethodCalls) > f.maxCalls {
+		// The request-level error of RFC 8620 §3.6.1
+		http.Error(w, `{"type":"urn:ietf:params:jmap:error:limit","limit":"maxCallsInRequest"}`, http.StatusBadRequest)
+
+		return
+	}
+
+	var names []string
+	var responses []rawInvocation
+	for _, invocation := range request.MethodCalls {
+		names = append(names, invocation.Name)
+
+		var args map[string]interface{}
+		if err := json.Unmarshal(invocation.Args, &args); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+		name, response := f.answer(invocation.Name, args)
+		raw, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+
+			return
+		}
+		responses = append(responses, rawInvocation{Name: name, Args: raw, CallID: invocation.CallID})
+	}
+	f.methods = append(f.methods, names)
+
+	writeJSON(w, apiResponse{MethodResponses: responses, SessionState: f.sessionState})
+}
+
+// answer returns the name and arguments of the response to one method call.
+func (f *fakeJMAP) answer(method string, args map[string]interface{}) (string, interface{}) {
+	switch method {
+	case "Mailbox/get":
+		list := f.mailboxes
+		if ids, ok := args["ids"].([]interface{}); ok {
+			list = nil
+			for _, mailbox := range f.mailboxes {
+				if slices.Contains(ids, interface{}(mailbox.Id)) {
+					list = append(list, mailbox)
+				}
+			}
+		}
+
+		return method, MailboxGetResponse{AccountId: fakeAccountId, State: strconv.Itoa(f.state), List: list}
+	case "Mailbox/changes":
+		since, err := strconv.Atoi(fmt.Sprint(args["sinceState"]))
+		if err != nil {
+			return "error", MethodError{Type: ErrorTypeCannotCalculateChanges}
+		}
+		var updated []string
+		for state := since + 1; state <= f.state; state++ {
+			updated = append(updated, f.updated[state]...)
+		}
+
+		return method, mailboxChangesResponse{
+			OldState: strconv.Itoa(since),
+			NewState: strconv.Itoa(f.state),
+			Updated:  updated,
+		}
+	case "Email/query":
+		return method, emailQueryResponse{Total: f.flaggedMatching(args["filter"])}
+	default:
+		return "error", MethodError{Type: ErrorTypeUnknownMethod}
+	}
+}
+
+// flaggedMatching counts the flagged emails that match an Email/query filter.
+// It understands AND and OR operators, inMailbox and inMailboxOtherThan,
+// and takes every email to have the keyword.
+func (f *fakeJMAP) flaggedMatching(filter interface{}) uint {
+	var total uint
+	for _, mailboxIds := range f.flagged {
+		if matchesEmail(filter, mailboxIds) {
+			total++
+		}
+	}
+
+	return total
+}
+
+// matchesEmail reports whether an email in the mailboxes matches a filter.
+func matchesEmail(filter interface{}, mailboxIds []string) bool {
+	conditions, _ := filter.(map[string]interface{})
+	switch conditions["operator"] {
+	case "AND", "OR":
+		all := conditions["operator"] == "AND"
+		for _, condition := range conditions["conditions"].([]interface{}) {
+			if matchesEmail(condition, mailboxIds) != all {
+				return !all
+			}
+		}
+
+		return all
+	}
+
+	if inMailbox, ok := conditions["inMailbox"].(string); ok && !slices.Contains(mailboxIds, inMailbox) {
+		return false
+	}
+	if otherThan, ok := conditions["inMailboxOtherThan"].([]interface{}); ok {
+		for _, id := range mailboxIds {
+			if !slices.Contains(otherThan, interface{}(id)) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return true
+}
+
+func (f *fakeJMAP) serveEvents(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.streams = append(f.streams, r.URL.RawQuery)
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+
+	for {
+		select {
+		case event := <-f.events:
+			_, _ = fmt.Fprint(w, event)
+			w.(http.Flusher).Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// setUnread changes the unread count of a mailbox in a new state.
+func (f *fakeJMAP) setUnread(id string, unread uint) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.state++
+	for i := range f.mailboxes {
+		if f.mailboxes[i].Id == id {
+			f.mailboxes[i].UnreadEmails = unread
+		}
+	}
+	f.updated[f.state] = append(f.updated[f.state], id)
+}
+
+// sendStateChange sends a push event with the current Mailbox state.
+func (f *fakeJMAP) sendStateChange(t *testing.T) {
+	t.Helper()
+
+	f.mu.Lock()
+	state := strconv.Itoa(f.state)
+	f.mu.Unlock()
+
+	data, err := json.Marshal(stateChange{
+		Type:    "StateChange",
+		Changed: map[string]map[string]string{fakeAccountId: {"Mailbox": state}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.events <- "event: state\ndata: " + string(data) + "\n\n"
+}
+
+// changeSession gives the session resource a new state.
+func (f *fakeJMAP) changeSession(state string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.sessionState = state
+}
+
+// fetchedSessions returns how many times the session resource was fetched.
+func (f *fakeJMAP) fetchedSessions() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.sessionFetches
+}
+
+// calledMethods returns the methods called so far, one request per entry.
+func (f *fakeJMAP) calledMethods() [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return slices.Clone(f.methods)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,117 @@
+package fastmail
+
+import (
+	"context"
+	"fmt"
+)
+
+// flaggedKeyword is the JMAP keyword of flagged emails, shown as pinned in the web app.
+const flaggedKeyword = "$flagged"
+
+type emailQueryResponse struct {
+	Total uint
+}
+
+// countFlagged counts the flagged emails in the mailboxes chosen by the
+// settings with one Email/query, so an email in several of them counts once.
+// The same request counts each mailbox on its own, for as many mailboxes as
+// the server's call limit allows, only to find the busiest one to open.
+func countFlagged(ctx context.Context, settings Settings, session *session, mailboxes []Mailbox) (Result, error) {
+	counted, tree, err := selectMailboxes(settings, mailboxes)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// A single mailbox's total is its own count
+	var breakdown []Mailbox
+	if len(counted) > 1 {
+		breakdown = counted[:min(len(counted), session.maxCalls()-1)]
+	}
+
+	methods := make([]methodCall, 0, 1+len(breakdown))
+	responses := make([]emailQueryResponse, 1+len(breakdown))
+	results := make([]interface{}, 0, 1+len(breakdown))
+	methods = append(methods, flaggedQuery(session, flaggedFilter(settings, counted, mailboxes)))
+	results = append(results, &responses[0])
+	for i, mailbox := range breakdown {
+		methods = append(methods, flaggedQuery(session, map[string]interface{}{
+			"inMailbox":  mailbox.Id,
+			"hasKeyword": flaggedKeyword,
+		}))
+		results = append(results, &responses[i+1])
+	}
+
+	if err := callAll(ctx, session, methods, results); err != nil {
+		return Result{}, fmt.Errorf("error while calling Email/query: %w", err)
+	}
+
+	total := responses[0].Total
+	if breakdown == nil {
+		return newResult(tree, counted, func(Mailbox) uint { return total }), nil
+	}
+
+	totals := make(map[string]uint, len(breakdown))
+	for i, mailbox := range breakdown {
+		totals[mailbox.Id] = responses[i+1].Total
+	}
+	result := newResult(tree, breakdown, func(mailbox Mailbox) uint {
+		return totals[mailbox.Id]
+	})
+	result.Distinct = &total
+
+	return result, nil
+}
+
+// flaggedFilter is the Email/query filter (RFC 8621 §4.4.1) of the flagged
+// emails in any of the counted mailboxes.
+func flaggedFilter(settings Settings, counted []Mailbox, mailboxes []Mailbox) map[string]interface{} {
+	if len(counted) == 1 {
+		return map[string]interface{}{
+			"inMailbox":  counted[0].Id,
+			"hasKeyword": flaggedKeyword,
+		}
+	}
+
+	if settings.MailboxMode == MailboxModeAll {
+		excluded := []string{}
+		for _, mailbox := range mailboxes {
+			if excludedRole(mailbox.Role) {
+				excluded = append(excluded, mailbox.Id)
+			}
+		}
+
+		return map[string]interface{}{
+			"inMailboxOtherThan": excluded,
+			"hasKeyword":         flaggedKeyword,
+		}
+	}
+
+	// A filter condition can't hold an operator, so the mailboxes are ORed
+	// in a condition of their own
+	inAny := make([]interface{}, 0, len(counted))
+	for _, mailbox := range counted {
+		inAny = append(inAny, map[string]interface{}{"inMailbox": mailbox.Id})
+	}
+
+	return map[string]interface{}{
+		"operator": "AND",
+		"conditions": []interface{}{
+			map[string]interface{}{"hasKeyword": flaggedKeyword},
+			map[string]interface{}{"operator": "OR", "conditions": inAny},
+		},
+	}
+}
+
+// flaggedQuery is an Email/query that only counts the emails that match filter.
+func flaggedQuery(session *session, filter map[string]interface{}) methodCall {
+	return methodCall{
+		Name: "Email/query",
+		Args: map[string]interface{}{
+			"accountId":      session.AccountId,
+			"filter":         filter,
+			"calculateTotal": true,
+			// Only the total is needed, not the ids
+			"limit": 0,
+		},
+	}
+}
@@ -0,0 +1,116 @@
+package fastmail
+
+import "testing"
+
+func TestCountFlagged(t *testing.T) {
+	mailboxes := []Mailbox{
+		{Id: "inbox", Name: "Inbox", Role: "inbox", SortOrder: 1},
+		{Id: "work", Name: "Work", SortOrder: 2},
+		{Id: "clients", Name: "Clients", SortOrder: 3},
+		{Id: "trash", Name: "Trash", Role: "trash", SortOrder: 4},
+	}
+	// The mailboxes of each flagged email. One of them is in two mailboxes.
+	flagged := [][]string{
+		{"inbox"},
+		{"inbox", "work"},
+		{"work"},
+		{"work"},
+		{"clients"},
+		{"trash"},
+	}
+
+	tests := []struct {
+		name       string
+		settings   Settings
+		maxCalls   int
+		wantTotal  uint
+		wantCalls  int
+		wantCounts map[string]uint
+		wantOpen   string
+	}{
+		{
+			name:       "the inbox",
+			wantTotal:  2,
+			wantCalls:  1,
+			wantCounts: map[string]uint{"Inbox": 2},
+			wantOpen:   "Inbox",
+		},
+		{
+			name:       "an email in two selected mailboxes counts once",
+			settings:   Settings{MailboxMode: MailboxModeSelected, MailboxIds: []string{"inbox", "work"}},
+			wantTotal:  4,
+			wantCalls:  3,
+			wantCounts: map[string]uint{"Inbox": 2, "Work": 3},
+			wantOpen:   "Work",
+		},
+		{
+			name:       "every mailbox but the trash",
+			settings:   Settings{MailboxMode: MailboxModeAll},
+			wantTotal:  5,
+			wantCalls:  4,
+			wantCounts: map[string]uint{"Inbox": 2, "Work": 3, "Clients": 1},
+			wantOpen:   "Work",
+		},
+		{
+			name:       "the call limit leaves mailboxes out of the breakdown",
+			settings:   Settings{MailboxMode: MailboxModeAll},
+			maxCalls:   2,
+			wantTotal:  5,
+			wantCalls:  2,
+			wantCounts: map[string]uint{"Inbox": 2},
+			wantOpen:   "Inbox",
+		},
+		{
+			name:       "a single call only counts the total",
+			settings:   Settings{MailboxMode: MailboxModeAll},
+			maxCalls:   1,
+			wantTotal:  5,
+			wantCalls:  1,
+			wantCounts: map[string]uint{},
+			wantOpen:   "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeJMAP(t, mailboxes...)
+			server.flagged = flagged
+			if tt.maxCalls > 0 {
+				server.maxCalls = tt.maxCalls
+			}
+			settings := tt.settings
+			settings.SessionUrl = server.settings().SessionUrl
+			settings.ApiToken = server.settings().ApiToken
+			settings.CountMode = CountModeFlagged
+
+			result, err := FetchUnseenCount(t.Context(), newSessionCache(), settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := result.Total(); got != tt.wantTotal {
+				t.Errorf("Total() = %d, want %d", got, tt.wantTotal)
+			}
+			if got := result.OpenPath(); got != tt.wantOpen {
+				t.Errorf("OpenPath() = %q, want %q", got, tt.wantOpen)
+			}
+			counts := map[string]uint{}
+			for _, mailbox := range result.Mailboxes {
+				counts[mailbox.Name] = mailbox.Count
+			}
+			if len(counts) != len(tt.wantCounts) {
+				t.Errorf("counted %v, want %v", counts, tt.wantCounts)
+			}
+			for name, want := range tt.wantCounts {
+				if counts[name] != want {
+					t.Errorf("%s count = %d, want %d", name, counts[name], want)
+				}
+			}
+
+			methods := server.calledMethods()
+			if query := methods[len(methods)-1]; len(query) != tt.wantCalls {
+				t.Errorf("made %d calls, want %d in one request: %v", len(query), tt.wantCalls, query)
+			}
+		})
+	}
+}
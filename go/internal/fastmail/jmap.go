@@ -0,0 +1,170 @@
+package fastmail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"ca.michaelabon.inboxes/internal/inbox"
+)
+
+// The JMAP capabilities (RFC 8620 §2 and RFC 8621 §1.3) that every request uses.
+const (
+	capabilityCore = "urn:ietf:params:jmap:core"
+	capabilityMail = "urn:ietf:params:jmap:mail"
+)
+
+// methodCall is one method call of a JMAP request.
+type methodCall struct {
+	Name string
+	Args interface{}
+}
+
+type apiRequest struct {
+	Using       []string        `json:"using"`
+	MethodCalls []rawInvocation `json:"methodCalls"`
+}
+
+type apiResponse struct {
+	MethodResponses []rawInvocation `json:"methodResponses"`
+	SessionState    string          `json:"sessionState"`
+}
+
+// call makes a single JMAP method call and decodes its response arguments into result.
+func call(ctx context.Context, session *session, method methodCall, result interface{}) error {
+	return callAll(ctx, session, []methodCall{method}, []interface{}{result})
+}
+
+// callAll makes several JMAP method calls in one request and decodes
+// the response arguments of each into the result at the same index.
+// It forgets the cached session if the credentials are rejected or the session has changed.
+func callAll(
+	ctx context.Context,
+	session *session,
+	methods []methodCall,
+	results []interface{},
+) error {
+	invocations := make([]rawInvocation, 0, len(methods))
+	for i, method := range methods {
+		rawArgs, err := json.Marshal(method.Args)
+		if err != nil {
+			return fmt.Errorf("error while marshalling %s arguments: %w", method.Name, err)
+		}
+		invocations = append(invocations, rawInvocation{Name: method.Name, Args: rawArgs, CallID: strconv.Itoa(i)})
+	}
+
+	body, err := json.Marshal(apiRequest{
+		Using:       []string{capabilityCore, capabilityMail},
+		MethodCalls: invocations,
+	})
+	if err != nil {
+		return fmt.Errorf("error while marshalling api request: %w", err)
+	}
+
+	rawApiResponse, err := makePostRequest(ctx, session.cache.client, session.server, session.ApiUrl, body)
+	if err != nil {
+		session.cache.forgetIfUnauthorized(session.server, err)
+
+		return fmt.Errorf("error while posting %s request: %w", methods[0].Name, err)
+	}
+
+	response := &apiResponse{}
+	if err := json.Unmarshal(rawApiResponse, response); err != nil {
+		return fmt.Errorf("error while unmarshalling api response: %w", err)
+	}
+	if response.SessionState != session.State {
+		// This response is still good, but the next request needs a fresh session
+		session.cache.forget(session.server)
+	}
+
+	answered := make([]bool, len(methods))
+	for _, invocation := range response.MethodResponses {
+		i, err := strconv.Atoi(invocation.CallID)
+		if err != nil || i < 0 || i >= len(methods) || answered[i] {
+			continue
+		}
+		if err := invocation.decode(methods[i].Name, results[i]); err != nil {
+			var methodErr *MethodError
+			if errors.As(err, &methodErr) && methodErr.Type == ErrorTypeAccountNotFound {
+				// The primary account may have moved since the session was fetched
+				session.cache.forget(session.server)
+			}
+
+			return err
+		}
+		answered[i] = true
+	}
+
+	for i, ok := range answered {
+		if !ok {
+			return fmt.Errorf("jmap: no response to %s", methods[i].Name)
+		}
+	}
+
+	return nil
+}
+
+type rawInvocation struct {
+	Name   string
+	Args   json.RawMessage
+	CallID string
+}
+
+func (i *rawInvocation) UnmarshalJSON(data []byte) error {
+	var methodName, callId string
+
+	// Slice so we can detect invalid size.
+	const correctSize = 3
+	triplet := make([]json.RawMessage, 0, correctSize)
+	if err := json.Unmarshal(data, &triplet); err != nil {
+		return fmt.Errorf("error while unmarshalling triplet: %w", err)
+	}
+	if len(triplet) != correctSize {
+		return fmt.Errorf(
+			"jmap: malformed Invocation object, need exactly 3 elements, got %d, %v",
+			len(triplet),
+			triplet,
+		)
+	}
+
+	if err := json.Unmarshal(triplet[0], &methodName); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(triplet[2], &callId); err != nil {
+		return err
+	}
+
+	i.Name = methodName
+	i.CallID = callId
+	i.Args = triplet[1]
+
+	return nil
+}
+
+// decode unmarshals the arguments of a response to method into result.
+// An "error" response is returned as a *MethodError, wrapped with its category.
+func (i rawInvocation) decode(method string, result interface{}) error {
+	switch i.Name {
+	case method:
+		if err := json.Unmarshal(i.Args, result); err != nil {
+			return fmt.Errorf("error while unmarshalling %s response: %w", method, err)
+		}
+
+		return nil
+	case "error":
+		methodErr := &MethodError{Method: method}
+		if err := json.Unmarshal(i.Args, methodErr); err != nil {
+			return fmt.Errorf("error while unmarshalling %s error: %w", method, err)
+		}
+
+		return inbox.WrapError(methodErr.Category(), methodErr)
+	default:
+		return fmt.Errorf("jmap: expected %s response, got %s", method, i.Name)
+	}
+}
+
+func (i rawInvocation) MarshalJSON() ([]byte, error) {
+	return json.Marshal([3]interface{}{i.Name, i.Args, i.CallID})
+}
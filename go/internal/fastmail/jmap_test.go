@@ -0,0 +1,138 @@
+package fastmail
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ca.michaelabon.inboxes/internal/inbox"
+)
+
+// fixtureSession is a cached session whose API answers every request
+// with a response recorded in testdata.
+func fixtureSession(t *testing.T, fixture string) *session {
+	t.Helper()
+
+	body, err := os.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(api.Close)
+
+	cache := newSessionCache()
+	server := server{SessionUrl: api.URL + "/session", Authorization: "Bearer the-token"}
+	cached := &session{
+		cache:     cache,
+		server:    server,
+		ApiUrl:    api.URL,
+		AccountId: "u1a2b3c4",
+		// The fixtures' session state, so the session stays cached
+		State: "cyrus-0;p-5;vfs-0",
+	}
+	cache.sessions[server] = cached
+
+	return cached
+}
+
+func TestMailboxGetFixtures(t *testing.T) {
+	tests := []struct {
+		fixture string
+		// wantType is the MethodError type, if the call fails with one.
+		wantType     string
+		wantCategory inbox.ErrorCategory
+		wantErr      bool
+		// wantForget is true if the session should no longer be cached.
+		wantForget bool
+	}{
+		{
+			fixture: "mailbox_get.json",
+		},
+		{
+			fixture: "empty.json",
+			wantErr: true,
+		},
+		{
+			fixture: "mismatched_call_id.json",
+			wantErr: true,
+		},
+		{
+			fixture:      "account_not_found.json",
+			wantType:     ErrorTypeAccountNotFound,
+			wantCategory: inbox.CategoryAuth,
+			wantErr:      true,
+			wantForget:   true,
+		},
+		{
+			fixture:      "forbidden.json",
+			wantType:     ErrorTypeForbidden,
+			wantCategory: inbox.CategoryAuth,
+			wantErr:      true,
+		},
+		{
+			fixture:      "unknown_method.json",
+			wantType:     ErrorTypeUnknownMethod,
+			wantCategory: inbox.CategoryConfig,
+			wantErr:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			session := fixtureSession(t, tt.fixture)
+
+			response, err := mailboxGet(t.Context(), session)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var methodErr *MethodError
+			isMethodErr := errors.As(err, &methodErr)
+			switch {
+			case tt.wantType == "" && isMethodErr:
+				t.Errorf("error = %v, want no MethodError", err)
+			case tt.wantType != "" && !isMethodErr:
+				t.Errorf("error = %v, want a MethodError", err)
+			case tt.wantType != "":
+				if methodErr.Type != tt.wantType {
+					t.Errorf("Type = %q, want %q", methodErr.Type, tt.wantType)
+				}
+				if methodErr.Method != "Mailbox/get" {
+					t.Errorf("Method = %q, want Mailbox/get", methodErr.Method)
+				}
+			}
+			if got := inbox.Categorize(err); tt.wantErr && got != tt.wantCategory {
+				t.Errorf("category = %v, want %v", got, tt.wantCategory)
+			}
+
+			_, cached := session.cache.sessions[session.server]
+			if cached == tt.wantForget {
+				t.Errorf("session cached = %v, want %v", cached, !tt.wantForget)
+			}
+
+			if tt.wantErr {
+				return
+			}
+			if response.State != "J7138" {
+				t.Errorf("State = %q, want J7138", response.State)
+			}
+			result, err := countUnread(Settings{}, response.List)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := result.Total(); got != 3 {
+				t.Errorf("inbox unread = %d, want 3", got)
+			}
+			tree := newMailboxTree(response.List)
+			if got := tree.path("P3V"); got != "Inbox/Urgent" {
+				t.Errorf("path = %q, want Inbox/Urgent", got)
+			}
+		})
+	}
+}
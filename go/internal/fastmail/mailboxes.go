@@ -0,0 +1,262 @@
+package fastmail
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"ca.michaelabon.inboxes/internal/inbox"
+)
+
+const (
+	// MailboxModeInbox counts the mailbox with the inbox role. This is the default.
+	MailboxModeInbox = "inbox"
+	// MailboxModeSelected counts the mailboxes in Settings.MailboxIds.
+	MailboxModeSelected = "selected"
+	// MailboxModeAll counts every mailbox except spam and trash.
+	MailboxModeAll = "all"
+)
+
+const (
+	// CountModeUnreadEmails counts unread emails. This is the default.
+	CountModeUnreadEmails = "unreadEmails"
+	// CountModeUnreadThreads counts threads with at least one unread email.
+	CountModeUnreadThreads = "unreadThreads"
+	// CountModeFlagged counts flagged (pinned) emails, read or not.
+	CountModeFlagged = "flagged"
+)
+
+// excludedRole reports whether MailboxModeAll leaves out mailboxes with the role.
+func excludedRole(role string) bool {
+	switch role {
+	case "junk", "spam", "trash":
+		return true
+	default:
+		return false
+	}
+}
+
+// MailboxCount is the count of one counted mailbox.
+type MailboxCount struct {
+	Id   string
+	Name string // Full path, e.g., "Clients/Urgent"
+	// Count is the number of unread emails, unread threads
+	// or flagged emails, depending on Settings.CountMode.
+	Count uint
+}
+
+// Result holds the count of each counted mailbox, in sort order.
+type Result struct {
+	Mailboxes []MailboxCount
+	// Distinct, if set, is the count of the mailboxes together, which is less
+	// than the sum of their counts when an email is in several of them.
+	// Mailboxes may then leave some of the counted mailboxes out.
+	Distinct *uint
+}
+
+// Total is the combined count shown on the key.
+func (r Result) Total() uint {
+	if r.Distinct != nil {
+		return *r.Distinct
+	}
+
+	var total uint
+	for _, mailbox := range r.Mailboxes {
+		total += mailbox.Count
+	}
+
+	return total
+}
+
+// OpenPath returns the mailbox to open: the one with the highest count,
+// or the first counted one if none has any.
+func (r Result) OpenPath() string {
+	if len(r.Mailboxes) == 0 {
+		return ""
+	}
+
+	busiest := r.Mailboxes[0]
+	for _, mailbox := range r.Mailboxes[1:] {
+		if mailbox.Count > busiest.Count {
+			busiest = mailbox
+		}
+	}
+
+	return busiest.Name
+}
+
+// mailboxURL returns the web app URL for a mailbox path.
+func mailboxURL(path string) string {
+	if path == "" {
+		return "https://app.fastmail.com/mail/Inbox"
+	}
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	return "https://app.fastmail.com/mail/" + strings.Join(segments, "/")
+}
+
+// countUnread counts the unread emails or threads
+// of the mailboxes chosen by the settings.
+func countUnread(settings Settings, mailboxes []Mailbox) (Result, error) {
+	counted, tree, err := selectMailboxes(settings, mailboxes)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return newResult(tree, counted, func(mailbox Mailbox) uint {
+		if settings.CountMode == CountModeUnreadThreads {
+			return mailbox.UnreadThreads
+		}
+
+		return mailbox.UnreadEmails
+	}), nil
+}
+
+// selectMailboxes picks the mailboxes chosen by the settings, in web app order,
+// with the tree that names them.
+func selectMailboxes(settings Settings, mailboxes []Mailbox) ([]Mailbox, mailboxTree, error) {
+	var counted []Mailbox
+	switch settings.MailboxMode {
+	case MailboxModeSelected:
+		selected := map[string]bool{}
+		for _, id := range settings.MailboxIds {
+			selected[id] = true
+		}
+		for _, mailbox := range mailboxes {
+			if selected[mailbox.Id] {
+				counted = append(counted, mailbox)
+			}
+		}
+		if len(counted) == 0 {
+			return nil, mailboxTree{}, inbox.NewError(inbox.CategoryConfig, "none of the selected mailboxes exist")
+		}
+	case MailboxModeAll:
+		for _, mailbox := range mailboxes {
+			if !excludedRole(mailbox.Role) {
+				counted = append(counted, mailbox)
+			}
+		}
+	default:
+		for _, mailbox := range mailboxes {
+			if mailbox.Role == "inbox" {
+				counted = append(counted, mailbox)
+
+				break
+			}
+		}
+		if len(counted) == 0 {
+			return nil, mailboxTree{}, fmt.Errorf("unable to find inbox in %d mailboxes", len(mailboxes))
+		}
+	}
+
+	tree := newMailboxTree(mailboxes)
+	tree.sort(counted)
+
+	return counted, tree, nil
+}
+
+// newResult counts each of the counted mailboxes with count.
+func newResult(tree mailboxTree, counted []Mailbox, count func(Mailbox) uint) Result {
+	result := Result{}
+	for _, mailbox := range counted {
+		result.Mailboxes = append(result.Mailboxes, MailboxCount{
+			Id:    mailbox.Id,
+			Name:  tree.path(mailbox.Id),
+			Count: count(mailbox),
+		})
+	}
+
+	return result
+}
+
+// mailboxTree knows where each mailbox sits in the hierarchy.
+type mailboxTree struct {
+	// chains holds each mailbox's ancestors and itself, from the root down.
+	chains map[string][]Mailbox
+}
+
+func newMailboxTree(mailboxes []Mailbox) mailboxTree {
+	byId := make(map[string]Mailbox, len(mailboxes))
+	for _, mailbox := range mailboxes {
+		byId[mailbox.Id] = mailbox
+	}
+
+	chains := make(map[string][]Mailbox, len(mailboxes))
+	for _, mailbox := range mailboxes {
+		chain := []Mailbox{mailbox}
+		// The depth limit guards against a parent cycle in a broken response
+		for parent, depth := mailbox.ParentId, 0; parent != "" && depth < len(mailboxes); depth++ {
+			p, ok := byId[parent]
+			if !ok {
+				break
+			}
+			chain = append([]Mailbox{p}, chain...)
+			parent = p.ParentId
+		}
+		chains[mailbox.Id] = chain
+	}
+
+	return mailboxTree{chains: chains}
+}
+
+// path returns the full name of a mailbox, e.g., "Clients/Urgent".
+func (t mailboxTree) path(id string) string {
+	chain := t.chains[id]
+	names := make([]string, 0, len(chain))
+	for _, mailbox := range chain {
+		names = append(names, mailbox.Name)
+	}
+
+	return strings.Join(names, "/")
+}
+
+// less orders mailboxes the way the web app lists them: parents before
+// their children, and siblings by sort order, then by name.
+func (t mailboxTree) less(a, b string) bool {
+	chainA, chainB := t.chains[a], t.chains[b]
+	for i := 0; i < len(chainA) && i < len(chainB); i++ {
+		x, y := chainA[i], chainB[i]
+		if x.Id == y.Id {
+			continue
+		}
+		if x.SortOrder != y.SortOrder {
+			return x.SortOrder < y.SortOrder
+		}
+
+		return x.Name < y.Name
+	}
+
+	return len(chainA) < len(chainB)
+}
+
+// sort orders mailboxes in place, see less.
+func (t mailboxTree) sort(mailboxes []Mailbox) {
+	sort.SliceStable(mailboxes, func(i, j int) bool {
+		return t.less(mailboxes[i].Id, mailboxes[j].Id)
+	})
+}
+
+// mailboxOption is a mailbox as listed in the property inspector.
+type mailboxOption struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// mailboxOptions lists every mailbox by path, in web app order.
+func mailboxOptions(mailboxes []Mailbox) []mailboxOption {
+	tree := newMailboxTree(mailboxes)
+	sorted := append([]Mailbox(nil), mailboxes...)
+	tree.sort(sorted)
+
+	options := make([]mailboxOption, 0, len(sorted))
+	for _, mailbox := range sorted {
+		options = append(options, mailboxOption{Id: mailbox.Id, Name: tree.path(mailbox.Id)})
+	}
+
+	return options
+}
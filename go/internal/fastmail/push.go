@@ -0,0 +1,243 @@
+package fastmail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ca.michaelabon.inboxes/internal/httpx"
+	"ca.michaelabon.inboxes/internal/inbox"
+)
+
+// pingInterval asks the server for a keep-alive event this often.
+const pingInterval = 30 * time.Second
+
+// streamIdleTimeout is how long the stream may stay silent,
+// allowing for a couple of late pings, before we reconnect.
+const streamIdleTimeout = 3 * pingInterval
+
+// stateChange is the data of a push "state" event (RFC 8620 §7.1):
+// the new state of each changed type, by account.
+type stateChange struct {
+	Type    string                       `json:"@type"`
+	Changed map[string]map[string]string `json:"changed"`
+}
+
+type mailboxChangesResponse struct {
+	OldState       string
+	NewState       string
+	HasMoreChanges bool
+	Created        []string
+	Updated        []string
+	Destroyed      []string
+}
+
+// WatchUnseenCount pushes the count as soon as it is known, then
+// subscribes to the session's event source (RFC 8620 §7.3) and pushes it
+// again whenever a Mailbox state change arrives, fetching only what changed.
+// Flagged counts also follow Email state changes, since flagging an email
+// doesn't change its mailbox.
+// It returns inbox.ErrPushUnsupported if the session has no event source.
+func WatchUnseenCount(
+	ctx context.Context,
+	sessions *sessionCache,
+	settings Settings,
+	push func(Result, error),
+) error {
+	return watchUnseenCount(ctx, sessions, settings, streamIdleTimeout, push)
+}
+
+// watchUnseenCount is WatchUnseenCount, but it returns httpx.ErrStreamIdle,
+// so the caller reconnects, once the event source is silent for idleTimeout.
+func watchUnseenCount(
+	ctx context.Context,
+	sessions *sessionCache,
+	settings Settings,
+	idleTimeout time.Duration,
+	push func(Result, error),
+) error {
+	server, err := settings.server()
+	if err != nil {
+		return err
+	}
+
+	session, err := sessions.get(ctx, server)
+	if err != nil {
+		return err
+	}
+	if session.EventSourceUrl == "" {
+		return inbox.ErrPushUnsupported
+	}
+
+	response, err := mailboxGet(ctx, session)
+	if err != nil {
+		return err
+	}
+	mailboxes, state := response.List, response.State
+	push(count(ctx, settings, session, mailboxes))
+
+	flagged := settings.CountMode == CountModeFlagged
+	types := []string{"Mailbox"}
+	if flagged {
+		types = append(types, "Email")
+	}
+	// Email states are only learned from events, so the first one always recounts
+	var emailState string
+
+	header := http.Header{}
+	header.Set("Authorization", server.Authorization)
+
+	eventUrl := expandEventSourceUrl(session.EventSourceUrl, types)
+	err = sessions.client.Stream(ctx, eventUrl, header, idleTimeout, func(event httpx.Event) error {
+		if event.Type != "state" {
+			return nil
+		}
+
+		var change stateChange
+		if err := json.Unmarshal([]byte(event.Data), &change); err != nil {
+			log.Println("[fastmail]", "ignoring malformed state event", err)
+
+			return nil
+		}
+
+		changed := change.Changed[session.AccountId]
+		newState, mailboxChanged := changed["Mailbox"]
+		mailboxChanged = mailboxChanged && newState != state
+		newEmailState, emailChanged := changed["Email"]
+		emailChanged = flagged && emailChanged && newEmailState != emailState
+		if !mailboxChanged && !emailChanged {
+			return nil
+		}
+
+		if mailboxChanged {
+			var err error
+			mailboxes, state, err = syncMailboxes(ctx, session, mailboxes, state)
+			if err != nil {
+				return err
+			}
+		}
+		if emailChanged {
+			emailState = newEmailState
+		}
+
+		countCtx, cancel := context.WithTimeout(ctx, inbox.FetchTimeout)
+		defer cancel()
+		push(count(countCtx, settings, session, mailboxes))
+
+		return nil
+	})
+	sessions.forgetIfUnauthorized(server, err)
+
+	return err
+}
+
+// expandEventSourceUrl fills in the event source URL template:
+// only changes to the given types, keep the connection open, and ping regularly.
+func expandEventSourceUrl(template string, types []string) string {
+	return strings.NewReplacer(
+		"{types}", strings.Join(types, ","),
+		"{closeafter}", "no",
+		"{ping}", strconv.Itoa(int(pingInterval.Seconds())),
+	).Replace(template)
+}
+
+// syncMailboxes brings a list of mailboxes up to date with Mailbox/changes,
+// then fetches only the mailboxes that were created or updated.
+// If the server can't calculate the changes, it fetches every mailbox again.
+func syncMailboxes(
+	ctx context.Context,
+	session *session,
+	mailboxes []Mailbox,
+	sinceState string,
+) ([]Mailbox, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, inbox.FetchTimeout)
+	defer cancel()
+
+	changed := map[string]bool{}
+	destroyed := map[string]bool{}
+	state := sinceState
+	for {
+		var changes mailboxChangesResponse
+		err := call(ctx, session, methodCall{
+			Name: "Mailbox/changes",
+			Args: map[string]interface{}{
+				"accountId":  session.AccountId,
+				"sinceState": state,
+			},
+		}, &changes)
+		var methodErr *MethodError
+		if errors.As(err, &methodErr) && methodErr.Type == ErrorTypeCannotCalculateChanges {
+			log.Println("[fastmail]", "fetching every mailbox, since the server can't calculate changes")
+
+			response, err := mailboxGet(ctx, session)
+			if err != nil {
+				return nil, "", err
+			}
+
+			return response.List, response.State, nil
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("error while calling Mailbox/changes: %w", err)
+		}
+
+		for _, id := range append(changes.Created, changes.Updated...) {
+			changed[id] = true
+			delete(destroyed, id)
+		}
+		for _, id := range changes.Destroyed {
+			destroyed[id] = true
+			delete(changed, id)
+		}
+		state = changes.NewState
+
+		if !changes.HasMoreChanges {
+			break
+		}
+	}
+
+	updated := make(map[string]Mailbox, len(changed))
+	if len(changed) > 0 {
+		ids := make([]string, 0, len(changed))
+		for id := range changed {
+			ids = append(ids, id)
+		}
+
+		var response MailboxGetResponse
+		err := call(ctx, session, methodCall{
+			Name: "Mailbox/get",
+			Args: map[string]interface{}{
+				"accountId": session.AccountId,
+				"ids":       ids,
+			},
+		}, &response)
+		if err != nil {
+			return nil, "", fmt.Errorf("error while calling Mailbox/get for changes: %w", err)
+		}
+		for _, mailbox := range response.List {
+			updated[mailbox.Id] = mailbox
+		}
+	}
+
+	synced := make([]Mailbox, 0, len(mailboxes)+len(updated))
+	for _, mailbox := range mailboxes {
+		if destroyed[mailbox.Id] {
+			continue
+		}
+		if fresh, ok := updated[mailbox.Id]; ok {
+			mailbox = fresh
+			delete(updated, mailbox.Id)
+		}
+		synced = append(synced, mailbox)
+	}
+	for _, mailbox := range updated {
+		synced = append(synced, mailbox)
+	}
+
+	return synced, state, nil
+}
@@ -0,0 +1,161 @@
+package fastmail
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"ca.michaelabon.inboxes/internal/httpx"
+	"ca.michaelabon.inboxes/internal/inbox"
+)
+
+// receive waits for the next push.
+func receive(t *testing.T, pushes <-chan Result, watchErr <-chan error) Result {
+	t.Helper()
+
+	select {
+	case result := <-pushes:
+		return result
+	case err := <-watchErr:
+		t.Fatalf("the watch ended before pushing: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing was pushed")
+	}
+
+	return Result{}
+}
+
+// startWatching watches the fake server in the background.
+func startWatching(
+	ctx context.Context,
+	t *testing.T,
+	server *fakeJMAP,
+	idleTimeout time.Duration,
+) (<-chan Result, <-chan error) {
+	t.Helper()
+
+	pushes := make(chan Result, 10)
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- watchUnseenCount(ctx, newSessionCache(), server.settings(), idleTimeout, func(result Result, err error) {
+			if err != nil {
+				t.Errorf("pushed an error: %v", err)
+
+				return
+			}
+			pushes <- result
+		})
+	}()
+
+	return pushes, watchErr
+}
+
+func TestWatchSyncsMailboxChanges(t *testing.T) {
+	server := newFakeJMAP(t,
+		Mailbox{Id: "inbox", Name: "Inbox", Role: "inbox", UnreadEmails: 1},
+		Mailbox{Id: "archive", Name: "Archive", Role: "archive"},
+	)
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	pushes, watchErr := startWatching(ctx, t, server, time.Minute)
+	if got := receive(t, pushes, watchErr).Total(); got != 1 {
+		t.Fatalf("first push = %d, want 1", got)
+	}
+
+	server.setUnread("inbox", 4)
+	server.sendStateChange(t)
+	if got := receive(t, pushes, watchErr).Total(); got != 4 {
+		t.Errorf("push after the state change = %d, want 4", got)
+	}
+
+	methods := server.calledMethods()
+	want := [][]string{{"Mailbox/get"}, {"Mailbox/changes"}, {"Mailbox/get"}}
+	if !slices.EqualFunc(methods, want, slices.Equal) {
+		t.Errorf("called %v, want %v", methods, want)
+	}
+
+	cancel()
+	if err := <-watchErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("watch ended with %v, want context.Canceled", err)
+	}
+}
+
+func TestWatchIgnoresStaleStateChanges(t *testing.T) {
+	server := newFakeJMAP(t, Mailbox{Id: "inbox", Name: "Inbox", Role: "inbox", UnreadEmails: 1})
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	pushes, watchErr := startWatching(ctx, t, server, time.Minute)
+	receive(t, pushes, watchErr)
+
+	// The state the mailboxes were fetched in has nothing new
+	server.sendStateChange(t)
+	server.setUnread("inbox", 2)
+	server.sendStateChange(t)
+	if got := receive(t, pushes, watchErr).Total(); got != 2 {
+		t.Errorf("push after the state change = %d, want 2", got)
+	}
+
+	if changes := len(server.calledMethods()); changes != 3 {
+		t.Errorf("made %d requests, want 3: the stale state change should be ignored", changes)
+	}
+}
+
+func TestWatchReconnectsWhenTheStreamGoesQuiet(t *testing.T) {
+	server := newFakeJMAP(t, Mailbox{Id: "inbox", Name: "Inbox", Role: "inbox", UnreadEmails: 1})
+
+	pushes, watchErr := startWatching(t.Context(), t, server, 50*time.Millisecond)
+	receive(t, pushes, watchErr)
+
+	// Nothing, not even a ping, arrives on the stream
+	select {
+	case err := <-watchErr:
+		if !errors.Is(err, httpx.ErrStreamIdle) {
+			t.Errorf("watch ended with %v, want httpx.ErrStreamIdle", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the idle watchdog never fired")
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.streams) != 1 {
+		t.Fatalf("opened %d streams, want 1", len(server.streams))
+	}
+	query, err := url.ParseQuery(server.streams[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"types": "Mailbox", "closeafter": "no", "ping": "30"} {
+		if got := query.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestWatchWithoutEventSource(t *testing.T) {
+	server := newFakeJMAP(t, Mailbox{Id: "inbox", Name: "Inbox", Role: "inbox", UnreadEmails: 1})
+	server.pushes = false
+
+	pushed := false
+	err := WatchUnseenCount(t.Context(), newSessionCache(), server.settings(), func(Result, error) { pushed = true })
+	if !errors.Is(err, inbox.ErrPushUnsupported) {
+		t.Errorf("watch ended with %v, want inbox.ErrPushUnsupported", err)
+	}
+	if pushed {
+		t.Error("pushed a result without an event source")
+	}
+
+	// Polling still works
+	result, err := FetchUnseenCount(t.Context(), newSessionCache(), server.settings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.Total(); got != 1 {
+		t.Errorf("polled count = %d, want 1", got)
+	}
+}
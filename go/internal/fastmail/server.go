@@ -0,0 +1,58 @@
+package fastmail
+
+import (
+	"encoding/base64"
+
+	"ca.michaelabon.inboxes/internal/inbox"
+)
+
+// DefaultSessionUrl is Fastmail's JMAP session resource.
+const DefaultSessionUrl = "https://api.fastmail.com/jmap/session"
+
+const (
+	// AuthMethodBearer sends Settings.ApiToken as a bearer token. This is the default.
+	AuthMethodBearer = "bearer"
+	// AuthMethodBasic sends Settings.Username and Settings.Password with HTTP Basic auth,
+	// which most self-hosted servers, like Stalwart and Cyrus, accept.
+	AuthMethodBasic = "basic"
+)
+
+// server is where a JMAP session is fetched from, and how to authenticate.
+type server struct {
+	SessionUrl string
+	// Authorization is the value of the Authorization header of every request.
+	Authorization string
+}
+
+// server reads the JMAP server and credentials from the settings.
+func (s Settings) server() (server, error) {
+	sessionUrl := s.SessionUrl
+	if sessionUrl == "" {
+		sessionUrl = DefaultSessionUrl
+	}
+
+	if s.AuthMethod == AuthMethodBasic {
+		if s.Username == "" {
+			return server{}, inbox.NewError(inbox.CategoryConfig, "missing Username")
+		}
+		if s.Password == "" {
+			return server{}, inbox.NewError(inbox.CategoryConfig, "missing Password")
+		}
+
+		credentials := base64.StdEncoding.EncodeToString([]byte(s.Username + ":" + s.Password))
+
+		return server{SessionUrl: sessionUrl, Authorization: "Basic " + credentials}, nil
+	}
+
+	if s.ApiToken == "" {
+		return server{}, inbox.NewError(inbox.CategoryConfig, "missing ApiToken")
+	}
+
+	return server{SessionUrl: sessionUrl, Authorization: "Bearer " + s.ApiToken}, nil
+}
+
+// isFastmail reports whether the settings point at Fastmail,
+// whose web app links can open a specific mailbox.
+func (s Settings) isFastmail() bool {
+	return s.SessionUrl == "" || s.SessionUrl == DefaultSessionUrl
+}
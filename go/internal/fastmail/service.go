@@ -10,10 +10,21 @@
 )
 
 // Service implements inbox.Service for Fastmail.
-type Service struct{}
+// Use NewService, which sets up its session cache.
+type Service struct {
+	sessions *sessionCache
+}
 
-// Compile-time check that Service implements the interface.
-var _ inbox.Service[*Settings, uint] = Service{}
+func NewService() Service {
+	return Service{sessions: newSessionCache()}
+}
+
+// Compile-time check that Service implements the interfaces.
+var (
+	_ inbox.Service[*Settings, Result]     = Service{}
+	_ inbox.SendToPluginHandler[*Settings] = Service{}
+	_ inbox.Pusher[*Settings, Result]      = Service{}
+)
 
 func (s Service) ActionUUID() string {
 	return "ca.michaelabon.streamdeck-inboxes.fastmail.action"
@@ -36,19 +47,70 @@ func (s Service) ParseSettings(raw json.RawMessage) (*Settings, error) {
 	return &settings, nil
 }
 
-func (s Service) FetchResult(ctx context.Context, settings *Settings) (uint, error) {
-	return FetchUnseenCount(*settings)
+func (s Service) FetchResult(ctx context.Context, settings *Settings) (Result, error) {
+	return FetchUnseenCount(ctx, s.sessions, *settings)
+}
+
+// Watch pushes the unread count over JMAP push,
+// so the key updates as soon as mail arrives.
+func (s Service) Watch(ctx context.Context, settings *Settings, push func(Result, error)) error {
+	return WatchUnseenCount(ctx, s.sessions, *settings, push)
 }
 
 func (s Service) Render(
 	ctx context.Context,
 	client *streamdeck.Client,
-	result uint,
+	result Result,
 	err error,
 ) error {
-	return inbox.RenderCount(ctx, client, result, err)
+	return inbox.RenderCount(ctx, client, result.Total(), err)
 }
 
-func (s Service) OpenURL(settings *Settings, result uint) string {
-	return "https://app.fastmail.com/mail/Inbox"
+// OpenURL opens the configured webmail, or else, on Fastmail,
+// the counted mailbox with the highest count.
+func (s Service) OpenURL(settings *Settings, result Result) string {
+	if settings.WebmailUrl != "" {
+		return settings.WebmailUrl
+	}
+	if !settings.isFastmail() {
+		return ""
+	}
+
+	return mailboxURL(result.OpenPath())
+}
+
+// HandleSendToPlugin processes messages from the property inspector.
+func (s Service) HandleSendToPlugin(
+	ctx context.Context,
+	client *streamdeck.Client,
+	payload json.RawMessage,
+	settings *Settings,
+) (interface{}, error) {
+	var request struct {
+		Action string `json:"action"`
+	}
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return nil, err
+	}
+
+	switch request.Action {
+	case "fetchMailboxes":
+		mailboxes, err := FetchMailboxes(ctx, s.sessions, *settings)
+		if err != nil {
+			// Return error as payload to PI, not as Go error
+			//nolint:nilerr // intentionally returning nil error with error payload
+			return map[string]interface{}{
+				"action": "fetchMailboxes",
+				"error":  err.Error(),
+			}, nil
+		}
+
+		return map[string]interface{}{
+			"action":    "fetchMailboxes",
+			"mailboxes": mailboxOptions(mailboxes),
+		}, nil
+	default:
+		//nolint:nilnil // unknown actions are intentionally ignored
+		return nil, nil
+	}
 }
@@ -0,0 +1,180 @@
+package fastmail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+
+	"ca.michaelabon.inboxes/internal/httpx"
+	"ca.michaelabon.inboxes/internal/inbox"
+)
+
+// SessionResponse is the part of the JMAP session resource (RFC 8620 §2) we use.
+type SessionResponse struct {
+	PrimaryAccounts map[string]string
+	// ApiUrl is where method calls are posted.
+	ApiUrl string
+	// EventSourceUrl is the URL template for push notifications (RFC 8620 §7.3).
+	EventSourceUrl string
+	// State changes whenever anything in the session changes.
+	State string
+	// Capabilities include the server's limits.
+	Capabilities capabilities
+}
+
+// capabilities are the parts of the server's capabilities (RFC 8620 §2) we use.
+type capabilities struct {
+	Core coreCapability `json:"urn:ietf:params:jmap:core"`
+}
+
+// coreCapability holds the limits that every JMAP server advertises.
+type coreCapability struct {
+	// MaxCallsInRequest is the most method calls one request may make.
+	MaxCallsInRequest int
+}
+
+// defaultMaxCallsInRequest is assumed when a session has no maxCallsInRequest,
+// which RFC 8620 requires, so it is deliberately small.
+const defaultMaxCallsInRequest = 4
+
+// session is a fetched session resource and the mail account to use.
+type session struct {
+	// cache holds this session until it is forgotten.
+	cache          *sessionCache
+	server         server
+	ApiUrl         string
+	EventSourceUrl string
+	AccountId      string
+	State          string
+	Capabilities   capabilities
+}
+
+// sessionCache caches the session resource by server and credentials, so each
+// poll makes a single request. An entry is dropped when an API response
+// reports a different session state, or when the credentials are rejected.
+// Every request made with its sessions shares its HTTP client.
+type sessionCache struct {
+	client *httpx.Client
+
+	mu       sync.Mutex
+	sessions map[server]*session
+}
+
+func newSessionCache() *sessionCache {
+	return &sessionCache{client: httpx.New("[fastmail]"), sessions: map[server]*session{}}
+}
+
+// get returns the cached session for the server, fetching it if needed.
+func (c *sessionCache) get(ctx context.Context, server server) (*session, error) {
+	c.mu.Lock()
+	cached := c.sessions[server]
+	c.mu.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	fetched, err := fetchSession(ctx, c.client, server)
+	if err != nil {
+		return nil, err
+	}
+	fetched.cache = c
+
+	c.mu.Lock()
+	c.sessions[server] = fetched
+	c.mu.Unlock()
+
+	return fetched, nil
+}
+
+// fetchSession fetches the JMAP session and finds the mail account.
+func fetchSession(ctx context.Context, client *httpx.Client, server server) (*session, error) {
+	rawSessionResponse, err := makeGetRequest(ctx, client, server, server.SessionUrl)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting session: %w", err)
+	}
+
+	sessionResponse := &SessionResponse{}
+	err = json.Unmarshal(rawSessionResponse, sessionResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error while unmarshalling session response: %w", err)
+	}
+	accountId, ok := sessionResponse.PrimaryAccounts[capabilityMail]
+	if !ok {
+		// The credentials can't see any mail account
+		return nil, inbox.WrapError(inbox.CategoryAuth, fmt.Errorf(
+			"error while retrieving primary account %v",
+			sessionResponse.PrimaryAccounts,
+		))
+	}
+	if sessionResponse.ApiUrl == "" {
+		return nil, errors.New("jmap: session has no apiUrl")
+	}
+
+	apiUrl, err := resolveUrl(server.SessionUrl, sessionResponse.ApiUrl)
+	if err != nil {
+		return nil, err
+	}
+	eventSourceUrl := sessionResponse.EventSourceUrl
+	if eventSourceUrl != "" {
+		if eventSourceUrl, err = resolveUrl(server.SessionUrl, eventSourceUrl); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Println("[fastmail]", "successfully got accountId", accountId)
+
+	return &session{
+		server:         server,
+		ApiUrl:         apiUrl,
+		EventSourceUrl: eventSourceUrl,
+		AccountId:      accountId,
+		State:          sessionResponse.State,
+		Capabilities:   sessionResponse.Capabilities,
+	}, nil
+}
+
+// maxCalls returns how many method calls one request may make,
+// assuming a small limit if the server doesn't say.
+func (s *session) maxCalls() int {
+	if s.Capabilities.Core.MaxCallsInRequest < 1 {
+		return defaultMaxCallsInRequest
+	}
+
+	return s.Capabilities.Core.MaxCallsInRequest
+}
+
+// resolveUrl resolves a URL from the session resource against the session URL,
+// since some servers advertise paths rather than absolute URLs.
+func resolveUrl(sessionUrl, ref string) (string, error) {
+	base, err := url.Parse(sessionUrl)
+	if err != nil {
+		return "", inbox.WrapError(inbox.CategoryConfig, fmt.Errorf("error while parsing session url: %w", err))
+	}
+	// Keep URL templates like {types} as they are
+	if base.IsAbs() && len(ref) > 0 && ref[0] == '/' {
+		return base.Scheme + "://" + base.Host + ref, nil
+	}
+
+	return ref, nil
+}
+
+// forget drops the cached session for the server,
+// so the next request fetches it again.
+func (c *sessionCache) forget(server server) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.sessions, server)
+}
+
+// forgetIfUnauthorized drops the cached session when err is a 401,
+// in case the credentials were replaced or their access changed.
+func (c *sessionCache) forgetIfUnauthorized(server server, err error) {
+	if errors.Is(err, httpx.ErrUnauthorized) {
+		c.forget(server)
+	}
+}
@@ -0,0 +1,59 @@
+package fastmail
+
+import "testing"
+
+func TestSessionCache(t *testing.T) {
+	tests := []struct {
+		name string
+		// between happens between the two fetches.
+		between func(t *testing.T, server *fakeJMAP, sessions *sessionCache) *sessionCache
+		// wantFetches is how many times the session resource is fetched.
+		wantFetches int
+	}{
+		{
+			name:        "reuses the session",
+			wantFetches: 1,
+		},
+		{
+			name: "refetches the session after its state changes",
+			between: func(t *testing.T, server *fakeJMAP, sessions *sessionCache) *sessionCache {
+				server.changeSession("session-2")
+				// This count still uses the old session, but learns that it changed
+				if _, err := FetchUnseenCount(t.Context(), sessions, server.settings()); err != nil {
+					t.Fatal(err)
+				}
+
+				return sessions
+			},
+			wantFetches: 2,
+		},
+		{
+			name: "services don't share sessions",
+			between: func(t *testing.T, server *fakeJMAP, sessions *sessionCache) *sessionCache {
+				return newSessionCache()
+			},
+			wantFetches: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeJMAP(t, Mailbox{Id: "inbox", Name: "Inbox", Role: "inbox", UnreadEmails: 1})
+			sessions := newSessionCache()
+
+			if _, err := FetchUnseenCount(t.Context(), sessions, server.settings()); err != nil {
+				t.Fatal(err)
+			}
+			if tt.between != nil {
+				sessions = tt.between(t, server, sessions)
+			}
+			if _, err := FetchUnseenCount(t.Context(), sessions, server.settings()); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := server.fetchedSessions(); got != tt.wantFetches {
+				t.Errorf("fetched the session %d times, want %d", got, tt.wantFetches)
+			}
+		})
+	}
+}
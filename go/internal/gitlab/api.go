@@ -1,210 +1,352 @@
 package gitlab
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"sync"
 	"time"
 
+	"ca.michaelabon.inboxes/internal/inbox"
 	gitlab "gitlab.com/gitlab-org/api/client-go"
 )
 
 const RefreshInterval = time.Minute
 
+// MinRefreshInterval protects the instance: every refresh makes several API calls.
+const MinRefreshInterval = 30 * time.Second
+
 type Result struct {
 	AssignedIssues uint
 	AssignedMRs    uint
 	ReviewMRs      uint
 	ToDos          uint
+
+	// Pipeline counts of the user's open MRs, in ModePipelines.
+	FailedPipelines  uint
+	RunningPipelines uint
+	PassedPipelines  uint
+	// FailingMRURL is the first open MR with a failed pipeline, if any.
+	FailingMRURL string
+
+	// Rows are the counts shown on the key, top to bottom.
+	Rows []Row
+	// InboxZero is whether every category that counts toward inbox zero is empty.
+	InboxZero bool
+	// Alert turns the key red.
+	Alert bool
 }
 
 type Settings struct {
 	PersonalAccessToken string `json:"personalAccessToken"`
 	Server              string `json:"server"`
-	Username            string `json:"-"`
-	UserID              int64  `json:"-"`
+	// Mode is ModeInbox (default) or ModePipelines.
+	Mode string `json:"mode"`
+	// Categories are shown on the key, every category if empty.
+	Categories inbox.OneOrMany[Category] `json:"categories"`
+	// ReviewMRs is ReviewMRsCombined (default) or ReviewMRsSeparate.
+	ReviewMRs string `json:"reviewMRs"`
+	// InboxZero are the categories that must be empty for the key to turn gold,
+	// the shown categories if empty.
+	InboxZero inbox.OneOrMany[Category] `json:"inboxZero"`
+	// Groups and Projects limit issues and MRs to these groups, with their
+	// subgroups, and projects, by full path. Everywhere if both are empty.
+	Groups   inbox.OneOrMany[string] `json:"groups"`
+	Projects inbox.OneOrMany[string] `json:"projects"`
+	// Labels must all be on an issue or MR for it to count, and ExcludeLabels must not.
+	Labels        inbox.OneOrMany[string] `json:"labels"`
+	ExcludeLabels inbox.OneOrMany[string] `json:"excludeLabels"`
+	// Drafts is DraftsExclude to leave draft MRs out, or empty to count them.
+	Drafts string `json:"drafts"`
 }
 
-const (
-	perPage        = 20
-	paginationType = "keyset"
-)
+// MaxCount is the highest count a category shows: two digits fit in each row of the key.
+const MaxCount = 99
 
-func FetchUnseenCount(settings *Settings) (Result, error) {
+// maxPerPage is the largest page GitLab serves.
+const maxPerPage = 100
+
+func FetchUnseenCount(ctx context.Context, identities *identityCache, settings *Settings) (Result, error) {
 	if settings.PersonalAccessToken == "" {
-		return Result{}, errors.New("missing PersonalAccessToken")
+		return Result{}, inbox.NewError(inbox.CategoryConfig, "missing PersonalAccessToken")
 	}
 	if settings.Server == "" {
-		return Result{}, errors.New("missing Server")
+		return Result{}, inbox.NewError(inbox.CategoryConfig, "missing Server")
 	}
 
-	return getUnreadCounts(settings)
-}
-
-func getUnreadCounts(settings *Settings) (Result, error) {
-	git, err := gitlab.NewClient(settings.PersonalAccessToken, gitlab.WithBaseURL(settings.Server))
+	git, user, err := newClient(ctx, identities, settings)
 	if err != nil {
-		return Result{}, fmt.Errorf("error while getting session: %w", err)
+		return Result{}, identities.forgetIfUnauthorized(settings, categorize(err))
 	}
 
-	if settings.UserID == 0 || settings.Username == "" {
-		user, _, err := git.Users.CurrentUser()
-		if err != nil {
-			return Result{}, fmt.Errorf("error while getting current user: %w", err)
-		}
-		settings.Username = user.Username
-		settings.UserID = user.ID
+	var result Result
+	if settings.Mode == ModePipelines {
+		result, err = getPipelineCounts(ctx, git, settings, user)
+	} else {
+		result, err = getUnreadCounts(ctx, git, settings, user)
+	}
+	if err != nil {
+		return Result{}, identities.forgetIfUnauthorized(settings, categorize(err))
 	}
+	result.layout(settings)
 
-	result := Result{}
+	return result, nil
+}
 
-	assignedIssues, err := getAssignedIssues(git, settings.Username)
-	if err != nil {
-		return Result{}, err
+// categorize maps GitLab API errors onto the inbox error categories.
+// Transport errors are already recognised by inbox.Categorize.
+func categorize(err error) error {
+	var errorResponse *gitlab.ErrorResponse
+	if !errors.As(err, &errorResponse) || errorResponse.Response == nil {
+		return err
 	}
-	result.AssignedIssues = assignedIssues
 
-	assignedMRs, err := getAssignedMRs(git, gitlab.AssigneeID(settings.UserID))
-	if err != nil {
-		return Result{}, err
+	switch status := errorResponse.Response.StatusCode; {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return inbox.WrapError(inbox.CategoryAuth, err)
+	case status == http.StatusTooManyRequests:
+		return inbox.WrapError(inbox.CategoryRateLimit, err)
+	case status >= http.StatusInternalServerError:
+		return inbox.WrapError(inbox.CategoryUpstream, err)
+	default:
+		return err
 	}
-	result.AssignedMRs = assignedMRs
+}
 
-	reviewMRs, err := getReviewMRs(git, gitlab.ReviewerID(settings.UserID))
+// newClient connects to the server and finds out who the token belongs to.
+func newClient(
+	ctx context.Context,
+	identities *identityCache,
+	settings *Settings,
+) (*gitlab.Client, identity, error) {
+	git, err := gitlab.NewClient(settings.PersonalAccessToken, gitlab.WithBaseURL(settings.Server))
 	if err != nil {
-		return Result{}, err
+		return nil, identity{}, fmt.Errorf("error while getting session: %w", err)
 	}
-	result.ReviewMRs = reviewMRs
 
-	todos, err := getTodos(git)
+	user, err := identities.get(ctx, git, settings)
 	if err != nil {
-		return Result{}, err
+		return nil, identity{}, err
 	}
-	result.ToDos = todos
 
-	return result, nil
+	return git, user, nil
 }
 
-func getAssignedIssues(git *gitlab.Client, username string) (uint, error) {
-	var result uint
-
-	options := gitlab.ListIssuesOptions{
-		AssigneeUsername: gitlab.Ptr(username),
-		State:            gitlab.Ptr("opened"),
-		Scope:            gitlab.Ptr("all"),
-		ListOptions: gitlab.ListOptions{
-			PerPage:    perPage,
-			Pagination: paginationType,
+func getUnreadCounts(ctx context.Context, git *gitlab.Client, settings *Settings, user identity) (Result, error) {
+	fetchers := map[Category]func(context.Context) (uint, error){
+		CategoryIssues: func(ctx context.Context) (uint, error) {
+			return getAssignedIssues(ctx, git, settings, user)
+		},
+		CategoryAssignedMRs: func(ctx context.Context) (uint, error) {
+			return getAssignedMRs(ctx, git, settings, user)
+		},
+		CategoryReviewMRs: func(ctx context.Context) (uint, error) {
+			return getReviewMRs(ctx, git, settings, user)
+		},
+		CategoryTodos: func(ctx context.Context) (uint, error) {
+			return getTodos(ctx, git)
 		},
 	}
-	var requestOptions []gitlab.RequestOptionFunc
-	for {
-		issues, response, err := git.Issues.ListIssues(&options, requestOptions...)
-		if err != nil {
-			return 0, fmt.Errorf("error while getting assigned issues: %w", err)
-		}
-		result += uint(len(issues))
 
-		if response.NextLink == "" {
-			break
-		}
+	// The categories don't depend on each other, so fetch them all at once
+	// and give up on the rest as soon as one fails.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	categories := settings.fetchedCategories()
+	counts := make([]uint, len(categories))
+	errs := make([]error, len(categories))
+	var wg sync.WaitGroup
+	for i, category := range categories {
+		wg.Go(func() {
+			counts[i], errs[i] = fetchers[category](ctx)
+			if errs[i] != nil {
+				cancel()
+			}
+		})
+	}
+	wg.Wait()
+
+	if err := firstError(errs); err != nil {
+		return Result{}, err
+	}
 
-		requestOptions = []gitlab.RequestOptionFunc{
-			gitlab.WithKeysetPaginationParameters(response.NextLink),
+	result := Result{}
+	for i, category := range categories {
+		switch category {
+		case CategoryIssues:
+			result.AssignedIssues = counts[i]
+		case CategoryAssignedMRs:
+			result.AssignedMRs = counts[i]
+		case CategoryReviewMRs:
+			result.ReviewMRs = counts[i]
+		case CategoryTodos:
+			result.ToDos = counts[i]
 		}
 	}
 
 	return result, nil
 }
 
-func getAssignedMRs(git *gitlab.Client, userID *gitlab.AssigneeIDValue) (uint, error) {
-	var result uint
+// firstError returns the error that made the others give up, if any,
+// rather than one of the cancellations it caused.
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return err
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// listFunc lists one page of items with the given list options.
+type listFunc[T any] func(options gitlab.ListOptions, requestOptions ...gitlab.RequestOptionFunc) ([]T, *gitlab.Response, error)
 
-	options := gitlab.ListMergeRequestsOptions{
-		AssigneeID: userID,
-		State:      gitlab.Ptr("opened"),
-		Scope:      gitlab.Ptr("all"),
-		ListOptions: gitlab.ListOptions{
-			PerPage:    perPage,
-			Pagination: paginationType,
-		},
+// countItems counts what list finds, up to MaxCount.
+// It asks for a single item and reads the total from the X-Total header.
+// GitLab leaves the header out when there are too many items to count
+// cheaply, so then it pages through them instead, stopping at MaxCount.
+func countItems[T any](ctx context.Context, list listFunc[T]) (uint, error) {
+	items, response, err := list(gitlab.ListOptions{PerPage: 1}, gitlab.WithContext(ctx))
+	if err != nil {
+		return 0, err
 	}
-	var requestOptions []gitlab.RequestOptionFunc
-	for {
-		issues, response, err := git.MergeRequests.ListMergeRequests(&options, requestOptions...)
+	if response.TotalItems > 0 || len(items) == 0 {
+		return min(uint(response.TotalItems), MaxCount), nil
+	}
+
+	var count uint
+	for page := int64(1); count < MaxCount; page++ {
+		items, response, err := list(gitlab.ListOptions{Page: page, PerPage: maxPerPage}, gitlab.WithContext(ctx))
 		if err != nil {
-			return 0, fmt.Errorf("error while getting assigned MRs: %w", err)
+			return 0, err
 		}
-		result += uint(len(issues))
+		count += uint(len(items))
 
-		if response.NextLink == "" {
+		if response.NextPage == 0 {
 			break
 		}
+	}
+
+	return min(count, MaxCount), nil
+}
 
-		requestOptions = []gitlab.RequestOptionFunc{
-			gitlab.WithKeysetPaginationParameters(response.NextLink),
+// countInScopes adds up what list finds in each of the settings' scopes, up to MaxCount.
+func countInScopes[T any](
+	ctx context.Context,
+	settings *Settings,
+	list func(scope scope) listFunc[T],
+) (uint, error) {
+	var total uint
+	for _, scope := range settings.scopes() {
+		count, err := countItems(ctx, list(scope))
+		if err != nil {
+			return 0, err
 		}
+		total += count
 	}
 
-	return result, nil
+	return min(total, MaxCount), nil
 }
 
-func getReviewMRs(git *gitlab.Client, userID *gitlab.ReviewerIDValue) (uint, error) {
-	var result uint
-
-	options := gitlab.ListMergeRequestsOptions{
-		ReviewerID: userID,
-		State:      gitlab.Ptr("opened"),
-		Scope:      gitlab.Ptr("all"),
-		ListOptions: gitlab.ListOptions{
-			PerPage:    perPage,
-			Pagination: paginationType,
-		},
+func getAssignedIssues(ctx context.Context, git *gitlab.Client, settings *Settings, user identity) (uint, error) {
+	options := gitlab.ListIssuesOptions{
+		AssigneeUsername: gitlab.Ptr(user.Username),
+		State:            gitlab.Ptr("opened"),
+		Scope:            gitlab.Ptr("all"),
+		Labels:           labelOptions(settings.Labels),
+		NotLabels:        labelOptions(settings.ExcludeLabels),
 	}
-	var requestOptions []gitlab.RequestOptionFunc
-	for {
-		issues, response, err := git.MergeRequests.ListMergeRequests(&options, requestOptions...)
-		if err != nil {
-			return 0, fmt.Errorf("error while getting review MRs: %w", err)
+	count, err := countInScopes(ctx, settings, func(scope scope) listFunc[*gitlab.Issue] {
+		return func(
+			listOptions gitlab.ListOptions,
+			requestOptions ...gitlab.RequestOptionFunc,
+		) ([]*gitlab.Issue, *gitlab.Response, error) {
+			options.ListOptions = listOptions
+
+			return list[*gitlab.Issue](git, scope.apiPath("issues"), &options, requestOptions)
 		}
-		result += uint(len(issues))
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error while getting assigned issues: %w", err)
+	}
 
-		if response.NextLink == "" {
-			break
-		}
+	return count, nil
+}
 
-		requestOptions = []gitlab.RequestOptionFunc{
-			gitlab.WithKeysetPaginationParameters(response.NextLink),
-		}
+func getAssignedMRs(ctx context.Context, git *gitlab.Client, settings *Settings, user identity) (uint, error) {
+	options := mergeRequestOptions(settings)
+	options.AssigneeID = gitlab.AssigneeID(user.UserID)
+
+	count, err := countMergeRequests(ctx, git, settings, options)
+	if err != nil {
+		return 0, fmt.Errorf("error while getting assigned MRs: %w", err)
 	}
 
-	return result, nil
+	return count, nil
 }
 
-func getTodos(git *gitlab.Client) (uint, error) {
-	var result uint
+func getReviewMRs(ctx context.Context, git *gitlab.Client, settings *Settings, user identity) (uint, error) {
+	options := mergeRequestOptions(settings)
+	options.ReviewerID = gitlab.ReviewerID(user.UserID)
 
-	options := gitlab.ListTodosOptions{
-		ListOptions: gitlab.ListOptions{
-			PerPage:    perPage,
-			Pagination: paginationType,
-		},
+	count, err := countMergeRequests(ctx, git, settings, options)
+	if err != nil {
+		return 0, fmt.Errorf("error while getting review MRs: %w", err)
 	}
-	var requestOptions []gitlab.RequestOptionFunc
-	for {
-		issues, response, err := git.Todos.ListTodos(&options, requestOptions...)
-		if err != nil {
-			return 0, fmt.Errorf("error while getting todos: %w", err)
-		}
-		result += uint(len(issues))
 
-		if response.NextLink == "" {
-			break
-		}
+	return count, nil
+}
+
+// mergeRequestOptions are the options shared by every MR count.
+func mergeRequestOptions(settings *Settings) gitlab.ListMergeRequestsOptions {
+	return gitlab.ListMergeRequestsOptions{
+		State:     gitlab.Ptr("opened"),
+		Scope:     gitlab.Ptr("all"),
+		Labels:    labelOptions(settings.Labels),
+		NotLabels: labelOptions(settings.ExcludeLabels),
+		Draft:     settings.draftOption(),
+	}
+}
 
-		requestOptions = []gitlab.RequestOptionFunc{
-			gitlab.WithKeysetPaginationParameters(response.NextLink),
+func countMergeRequests(
+	ctx context.Context,
+	git *gitlab.Client,
+	settings *Settings,
+	options gitlab.ListMergeRequestsOptions,
+) (uint, error) {
+	return countInScopes(ctx, settings, func(scope scope) listFunc[*gitlab.BasicMergeRequest] {
+		return func(
+			listOptions gitlab.ListOptions,
+			requestOptions ...gitlab.RequestOptionFunc,
+		) ([]*gitlab.BasicMergeRequest, *gitlab.Response, error) {
+			options.ListOptions = listOptions
+
+			return list[*gitlab.BasicMergeRequest](git, scope.apiPath("merge_requests"), &options, requestOptions)
 		}
+	})
+}
+
+func getTodos(ctx context.Context, git *gitlab.Client) (uint, error) {
+	options := gitlab.ListTodosOptions{}
+	count, err := countItems(ctx, func(
+		listOptions gitlab.ListOptions,
+		requestOptions ...gitlab.RequestOptionFunc,
+	) ([]*gitlab.Todo, *gitlab.Response, error) {
+		options.ListOptions = listOptions
+
+		return git.Todos.ListTodos(&options, requestOptions...)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error while getting todos: %w", err)
 	}
 
-	return result, nil
+	return count, nil
 }
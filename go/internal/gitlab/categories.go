@@ -0,0 +1,126 @@
+package gitlab
+
+import "slices"
+
+// Category is a kind of item that a GitLab key can count.
+type Category string
+
+const (
+	CategoryIssues      Category = "issues"
+	CategoryAssignedMRs Category = "assignedMRs"
+	CategoryReviewMRs   Category = "reviewMRs"
+	CategoryTodos       Category = "todos"
+)
+
+// allCategories returns the categories shown when none are chosen,
+// in the order of the rows on the key.
+func allCategories() []Category {
+	return []Category{CategoryIssues, CategoryAssignedMRs, CategoryReviewMRs, CategoryTodos}
+}
+
+const (
+	// ReviewMRsCombined shows review MRs on one row with assigned MRs. This is the default.
+	ReviewMRsCombined = ""
+	// ReviewMRsSeparate gives review MRs a row of their own.
+	ReviewMRsSeparate = "separate"
+)
+
+// only keeps the known categories of chosen, in row order,
+// or returns fallback if none are left.
+func only(chosen []Category, fallback []Category) []Category {
+	var kept []Category
+	for _, category := range allCategories() {
+		if slices.Contains(chosen, category) {
+			kept = append(kept, category)
+		}
+	}
+	if len(kept) == 0 {
+		return fallback
+	}
+
+	return kept
+}
+
+// shownCategories are the categories shown on the key, in row order.
+func (s Settings) shownCategories() []Category {
+	return only(s.Categories, allCategories())
+}
+
+// inboxZeroCategories are the categories that must be empty for the key to turn gold.
+// They default to the shown categories, but need not be shown.
+func (s Settings) inboxZeroCategories() []Category {
+	return only(s.InboxZero, s.shownCategories())
+}
+
+// fetchedCategories are the categories that are either shown or count toward inbox zero.
+func (s Settings) fetchedCategories() []Category {
+	return only(slices.Concat(s.shownCategories(), s.inboxZeroCategories()), nil)
+}
+
+// Row is one count shown on the key.
+type Row struct {
+	// Kind picks the row's colour: "issues", "mrs", "review" or "todos",
+	// or "failed", "running" or "passed" in ModePipelines.
+	Kind  string
+	Count uint
+}
+
+// count returns the count of one category.
+func (r Result) count(category Category) uint {
+	switch category {
+	case CategoryIssues:
+		return r.AssignedIssues
+	case CategoryAssignedMRs:
+		return r.AssignedMRs
+	case CategoryReviewMRs:
+		return r.ReviewMRs
+	case CategoryTodos:
+		return r.ToDos
+	default:
+		return 0
+	}
+}
+
+// layout decides the rows to show and whether the key is at inbox zero.
+func (r *Result) layout(settings *Settings) {
+	if settings.Mode == ModePipelines {
+		r.Rows = []Row{
+			{Kind: "failed", Count: r.FailedPipelines},
+			{Kind: "running", Count: r.RunningPipelines},
+			{Kind: "passed", Count: r.PassedPipelines},
+		}
+		r.InboxZero = r.FailedPipelines == 0
+		r.Alert = r.FailedPipelines > 0
+
+		return
+	}
+
+	shown := settings.shownCategories()
+	combineMRs := settings.ReviewMRs != ReviewMRsSeparate &&
+		slices.Contains(shown, CategoryAssignedMRs) && slices.Contains(shown, CategoryReviewMRs)
+
+	r.Rows = nil
+	for _, category := range shown {
+		switch {
+		case category == CategoryIssues:
+			r.Rows = append(r.Rows, Row{Kind: "issues", Count: r.AssignedIssues})
+		case category == CategoryAssignedMRs && combineMRs:
+			r.Rows = append(r.Rows, Row{Kind: "mrs", Count: min(r.AssignedMRs+r.ReviewMRs, MaxCount)})
+		case category == CategoryAssignedMRs:
+			r.Rows = append(r.Rows, Row{Kind: "mrs", Count: r.AssignedMRs})
+		case category == CategoryReviewMRs && combineMRs:
+			// Already counted with the assigned MRs
+		case category == CategoryReviewMRs:
+			r.Rows = append(r.Rows, Row{Kind: "review", Count: r.ReviewMRs})
+		case category == CategoryTodos:
+			r.Rows = append(r.Rows, Row{Kind: "todos", Count: r.ToDos})
+		}
+	}
+
+	r.InboxZero = true
+	for _, category := range settings.inboxZeroCategories() {
+		if r.count(category) > 0 {
+			r.InboxZero = false
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package gitlab
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLayout(t *testing.T) {
+	counts := Result{AssignedIssues: 1, AssignedMRs: 2, ReviewMRs: 3}
+
+	tests := []struct {
+		name          string
+		settings      Settings
+		wantRows      []Row
+		wantInboxZero bool
+	}{
+		{
+			name: "every category, with review MRs on the MR row",
+			wantRows: []Row{
+				{Kind: "issues", Count: 1},
+				{Kind: "mrs", Count: 5},
+				{Kind: "todos", Count: 0},
+			},
+		},
+		{
+			name:     "review MRs on a row of their own",
+			settings: Settings{ReviewMRs: ReviewMRsSeparate},
+			wantRows: []Row{
+				{Kind: "issues", Count: 1},
+				{Kind: "mrs", Count: 2},
+				{Kind: "review", Count: 3},
+				{Kind: "todos", Count: 0},
+			},
+		},
+		{
+			name:     "only the chosen categories, in row order",
+			settings: Settings{Categories: []Category{CategoryTodos, CategoryIssues, "unknown"}},
+			wantRows: []Row{{Kind: "issues", Count: 1}, {Kind: "todos", Count: 0}},
+		},
+		{
+			name: "inbox zero only looks at its own categories",
+			settings: Settings{
+				Categories: []Category{CategoryIssues},
+				InboxZero:  []Category{CategoryTodos},
+			},
+			wantRows:      []Row{{Kind: "issues", Count: 1}},
+			wantInboxZero: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := counts
+			result.layout(&tt.settings)
+
+			if !slices.Equal(result.Rows, tt.wantRows) {
+				t.Errorf("Rows = %v, want %v", result.Rows, tt.wantRows)
+			}
+			if result.InboxZero != tt.wantInboxZero {
+				t.Errorf("InboxZero = %v, want %v", result.InboxZero, tt.wantInboxZero)
+			}
+		})
+	}
+}
+
+func TestRenderSVG(t *testing.T) {
+	svg, err := NewService().renderSVG([]Row{{Kind: "issues", Count: 7}, {Kind: "todos", Count: 42}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{`id="issues-background"`, `id="todos-background"`, ">42</text", "alert"} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("the key image has no %s", want)
+		}
+	}
+}
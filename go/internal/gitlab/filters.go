@@ -0,0 +1,85 @@
+package gitlab
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// DraftsExclude leaves draft MRs out of the counts.
+const DraftsExclude = "exclude"
+
+// items splits each item of a list on commas, because text inputs in the
+// property inspector hold comma-separated values, and drops blank items
+// and the spaces around the others.
+func items(list []string) []string {
+	var kept []string
+	for _, item := range list {
+		for part := range strings.SplitSeq(item, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				kept = append(kept, part)
+			}
+		}
+	}
+
+	return kept
+}
+
+// list gets one page of items from a list endpoint. The global, group and
+// project endpoints of issues and MRs take the same options, so one options
+// struct serves all three.
+func list[T any](
+	git *gitlab.Client,
+	path string,
+	options any,
+	requestOptions []gitlab.RequestOptionFunc,
+) ([]T, *gitlab.Response, error) {
+	req, err := git.NewRequest(http.MethodGet, path, options, requestOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var items []T
+	response, err := git.Do(req, &items)
+	if err != nil {
+		return nil, response, err
+	}
+
+	return items, response, nil
+}
+
+// labelOptions returns the label filter for the API, or nil if there is none.
+func labelOptions(list []string) *gitlab.LabelOptions {
+	labels := items(list)
+	if len(labels) == 0 {
+		return nil
+	}
+
+	options := gitlab.LabelOptions(labels)
+
+	return &options
+}
+
+// draftOption returns the draft filter for the API, or nil to count drafts too.
+func (s Settings) draftOption() *bool {
+	if s.Drafts != DraftsExclude {
+		return nil
+	}
+
+	return gitlab.Ptr(false)
+}
+
+// addFilters adds the label and draft filters to a list page's query.
+func (s Settings) addFilters(query url.Values, mergeRequests bool) {
+	for _, label := range items(s.Labels) {
+		query.Add("label_name[]", label)
+	}
+	for _, label := range items(s.ExcludeLabels) {
+		query.Add("not[label_name][]", label)
+	}
+	if mergeRequests && s.Drafts == DraftsExclude {
+		query.Set("draft", "no")
+	}
+}
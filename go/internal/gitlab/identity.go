@@ -0,0 +1,145 @@
+package gitlab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+
+	"ca.michaelabon.inboxes/internal/inbox"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// identity is the user a personal access token belongs to.
+type identity struct {
+	UserID   int64
+	Username string
+	Name     string
+}
+
+// identityCache caches identities by server and token. Settings are parsed
+// afresh on every key press and settings change, so they can't hold it.
+type identityCache struct {
+	mu      sync.Mutex
+	byToken map[string]identity
+}
+
+func newIdentityCache() *identityCache {
+	return &identityCache{byToken: map[string]identity{}}
+}
+
+func identityCacheKey(settings *Settings) string {
+	return settings.Server + "\x00" + settings.PersonalAccessToken
+}
+
+// cached returns the identity of the settings' token, if it is already known.
+func (c *identityCache) cached(settings *Settings) (identity, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	user, ok := c.byToken[identityCacheKey(settings)]
+
+	return user, ok
+}
+
+// get returns the identity of the settings' token,
+// asking the server only the first time.
+func (c *identityCache) get(ctx context.Context, git *gitlab.Client, settings *Settings) (identity, error) {
+	if user, ok := c.cached(settings); ok {
+		return user, nil
+	}
+
+	return c.lookup(ctx, git, settings)
+}
+
+// lookup asks the server who the settings' token belongs to and caches the answer.
+func (c *identityCache) lookup(ctx context.Context, git *gitlab.Client, settings *Settings) (identity, error) {
+	current, _, err := git.Users.CurrentUser(gitlab.WithContext(ctx))
+	if err != nil {
+		return identity{}, fmt.Errorf("error while getting current user: %w", err)
+	}
+	user := identity{UserID: current.ID, Username: current.Username, Name: current.Name}
+
+	c.mu.Lock()
+	c.byToken[identityCacheKey(settings)] = user
+	c.mu.Unlock()
+
+	return user, nil
+}
+
+// forget drops the cached identity of a token that the server rejected.
+func (c *identityCache) forget(settings *Settings) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.byToken, identityCacheKey(settings))
+}
+
+// forgetIfUnauthorized drops the token's cached identity if the server rejected it,
+// so a token given to someone else isn't counted as the old user.
+func (c *identityCache) forgetIfUnauthorized(settings *Settings, err error) error {
+	if inbox.Categorize(err) == inbox.CategoryAuth {
+		c.forget(settings)
+	}
+
+	return err
+}
+
+// TokenInfo describes a personal access token for the property inspector.
+type TokenInfo struct {
+	Username string   `json:"username"`
+	Name     string   `json:"name"`
+	Scopes   []string `json:"scopes"`
+	// ExpiresAt is the date the token expires, empty if it never does.
+	ExpiresAt string `json:"expiresAt,omitempty"`
+	// Warning explains why the key won't work with this token, if it won't.
+	Warning string `json:"warning,omitempty"`
+}
+
+// ValidateToken checks the settings' token, refreshes its cached identity,
+// and reports who it belongs to and what it may do.
+func ValidateToken(ctx context.Context, identities *identityCache, settings *Settings) (TokenInfo, error) {
+	if settings.PersonalAccessToken == "" {
+		return TokenInfo{}, inbox.NewError(inbox.CategoryConfig, "missing PersonalAccessToken")
+	}
+	if settings.Server == "" {
+		return TokenInfo{}, inbox.NewError(inbox.CategoryConfig, "missing Server")
+	}
+
+	git, err := gitlab.NewClient(settings.PersonalAccessToken, gitlab.WithBaseURL(settings.Server))
+	if err != nil {
+		return TokenInfo{}, fmt.Errorf("error while getting session: %w", err)
+	}
+
+	user, err := identities.lookup(ctx, git, settings)
+	if err != nil {
+		identities.forget(settings)
+
+		return TokenInfo{}, categorize(err)
+	}
+	info := TokenInfo{Username: user.Username, Name: user.Name}
+
+	token, _, err := git.PersonalAccessTokens.GetSinglePersonalAccessToken(gitlab.WithContext(ctx))
+	var errorResponse *gitlab.ErrorResponse
+	switch {
+	case errors.As(err, &errorResponse) && errorResponse.Response != nil &&
+		errorResponse.Response.StatusCode == http.StatusNotFound:
+		// Servers older than GitLab 15.5 can't describe the token
+		log.Println("[gitlab]", "the server can't report the token's scopes", err)
+	case err != nil:
+		return TokenInfo{}, categorize(fmt.Errorf("error while getting the token's scopes: %w", err))
+	default:
+		info.Scopes = token.Scopes
+		if token.ExpiresAt != nil {
+			info.ExpiresAt = token.ExpiresAt.String()
+		}
+		if !slices.Contains(token.Scopes, "api") && !slices.Contains(token.Scopes, "read_api") {
+			info.Warning = "the token needs the read_api scope"
+		}
+	}
+
+	return info, nil
+}
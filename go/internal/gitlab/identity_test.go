@@ -0,0 +1,81 @@
+package gitlab
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"ca.michaelabon.inboxes/internal/inbox"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// fakeUserEndpoint answers every request with status, unless it is 200,
+// when it answers GET /api/v4/user with the user and counts those requests.
+func fakeUserEndpoint(t *testing.T, status *atomic.Int64, requests *atomic.Int64) *Settings {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if code := int(status.Load()); code != http.StatusOK {
+			w.WriteHeader(code)
+			_, _ = w.Write([]byte(`{"message":"401 Unauthorized"}`))
+
+			return
+		}
+		if r.URL.Path != "/api/v4/user" {
+			http.NotFound(w, r)
+
+			return
+		}
+		requests.Add(1)
+		_, _ = w.Write([]byte(`{"id":42,"username":"ada","name":"Ada Lovelace"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	return &Settings{Server: server.URL, PersonalAccessToken: "the-token"}
+}
+
+func TestIdentityCache(t *testing.T) {
+	var status, requests atomic.Int64
+	status.Store(http.StatusOK)
+	settings := fakeUserEndpoint(t, &status, &requests)
+	git, err := gitlab.NewClient(settings.PersonalAccessToken, gitlab.WithBaseURL(settings.Server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	identities := newIdentityCache()
+
+	for range 3 {
+		user, err := identities.get(t.Context(), git, settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.UserID != 42 || user.Username != "ada" {
+			t.Errorf("identity = %+v, want ada (42)", user)
+		}
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("asked for the user %d times, want once", got)
+	}
+
+	if _, known := newIdentityCache().cached(settings); known {
+		t.Error("a new cache already knows the identity")
+	}
+
+	// The token is revoked, so the next count is turned away
+	status.Store(http.StatusUnauthorized)
+	if _, err := FetchUnseenCount(t.Context(), identities, settings); inbox.Categorize(err) != inbox.CategoryAuth {
+		t.Errorf("error = %v, want an auth error", err)
+	}
+	if _, known := identities.cached(settings); known {
+		t.Error("the identity of a rejected token is still cached")
+	}
+
+	if _, err := FetchUnseenCount(t.Context(), identities, settings); inbox.Categorize(err) != inbox.CategoryAuth {
+		t.Errorf("error = %v, want an auth error", err)
+	}
+	if _, known := identities.cached(settings); known {
+		t.Error("a failed lookup was cached")
+	}
+}
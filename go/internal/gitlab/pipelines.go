@@ -0,0 +1,161 @@
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"sync"
+
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+const (
+	// ModeInbox counts issues, MRs and to-dos. This is the default.
+	ModeInbox = ""
+	// ModePipelines shows the pipeline status of the user's open MRs.
+	ModePipelines = "pipelines"
+)
+
+// maxPipelineMRs is the most open MRs whose pipelines are checked, most recently updated first.
+const maxPipelineMRs = 50
+
+// pipelineWorkers is how many MRs are fetched at once:
+// the MR list doesn't include the head pipeline.
+const pipelineWorkers = 8
+
+// pipelineStatus groups GitLab's pipeline statuses into what the key shows.
+func pipelineStatus(status string) string {
+	switch status {
+	case "failed":
+		return "failed"
+	case "created", "waiting_for_resource", "preparing", "pending", "running", "scheduled":
+		return "running"
+	case "success":
+		return "passed"
+	default:
+		// Canceled, skipped, manual, or no pipeline at all
+		return ""
+	}
+}
+
+// getPipelineCounts counts the head pipelines of the user's open MRs by status,
+// and finds the first MR whose pipeline failed.
+func getPipelineCounts(ctx context.Context, git *gitlab.Client, settings *Settings, user identity) (Result, error) {
+	mergeRequests, err := getAuthoredMRs(ctx, git, settings, user)
+	if err != nil {
+		return Result{}, fmt.Errorf("error while getting authored MRs: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	statuses := make([]string, len(mergeRequests))
+	errs := make([]error, len(mergeRequests))
+	workers := make(chan struct{}, pipelineWorkers)
+	var wg sync.WaitGroup
+	for i, mergeRequest := range mergeRequests {
+		wg.Go(func() {
+			workers <- struct{}{}
+			defer func() { <-workers }()
+
+			full, _, err := git.MergeRequests.GetMergeRequest(
+				mergeRequest.ProjectID,
+				mergeRequest.IID,
+				nil,
+				gitlab.WithContext(ctx),
+			)
+			if err != nil {
+				errs[i] = fmt.Errorf("error while getting MR %s: %w", mergeRequest.WebURL, err)
+				cancel()
+
+				return
+			}
+			if full.HeadPipeline != nil {
+				statuses[i] = pipelineStatus(full.HeadPipeline.Status)
+			}
+		})
+	}
+	wg.Wait()
+
+	if err := firstError(errs); err != nil {
+		return Result{}, err
+	}
+
+	result := Result{}
+	for i, status := range statuses {
+		switch status {
+		case "failed":
+			result.FailedPipelines++
+			if result.FailingMRURL == "" {
+				result.FailingMRURL = mergeRequests[i].WebURL
+			}
+		case "running":
+			result.RunningPipelines++
+		case "passed":
+			result.PassedPipelines++
+		}
+	}
+
+	return result, nil
+}
+
+// getAuthoredMRs lists the user's open MRs in the settings' scopes,
+// most recently updated first, up to maxPipelineMRs.
+func getAuthoredMRs(
+	ctx context.Context,
+	git *gitlab.Client,
+	settings *Settings,
+	user identity,
+) ([]*gitlab.BasicMergeRequest, error) {
+	options := mergeRequestOptions(settings)
+	options.AuthorID = gitlab.Ptr(user.UserID)
+	options.OrderBy = gitlab.Ptr("updated_at")
+	options.ListOptions = gitlab.ListOptions{PerPage: maxPipelineMRs}
+
+	var mergeRequests []*gitlab.BasicMergeRequest
+	for _, scope := range settings.scopes() {
+		page, _, err := list[*gitlab.BasicMergeRequest](
+			git,
+			scope.apiPath("merge_requests"),
+			&options,
+			[]gitlab.RequestOptionFunc{gitlab.WithContext(ctx)},
+		)
+		if err != nil {
+			return nil, err
+		}
+		mergeRequests = append(mergeRequests, page...)
+	}
+
+	// Each scope is already in order, but not with each other
+	slices.SortStableFunc(mergeRequests, func(a, b *gitlab.BasicMergeRequest) int {
+		if a.UpdatedAt == nil || b.UpdatedAt == nil {
+			return 0
+		}
+
+		return b.UpdatedAt.Compare(*a.UpdatedAt)
+	})
+	if len(mergeRequests) > maxPipelineMRs {
+		mergeRequests = mergeRequests[:maxPipelineMRs]
+	}
+
+	return mergeRequests, nil
+}
+
+// pipelinesURL opens the first MR with a failed pipeline,
+// or else the list of the user's open MRs.
+func pipelinesURL(gitlabURL *url.URL, settings *Settings, user identity, result Result) string {
+	if result.FailingMRURL != "" {
+		return result.FailingMRURL
+	}
+
+	webScope := settings.webScope()
+
+	gitlabURL = gitlabURL.JoinPath(webScope.webPath("merge_requests"))
+	query := url.Values{}
+	query.Set("author_username", user.Username)
+	settings.addFilters(query, true)
+	gitlabURL.RawQuery = query.Encode()
+
+	return gitlabURL.String()
+}
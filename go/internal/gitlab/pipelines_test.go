@@ -0,0 +1,194 @@
+package gitlab
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeMR is an open MR of the user on the fake server.
+type fakeMR struct {
+	iid int
+	// status is its head pipeline's status, or empty if it has none.
+	status string
+}
+
+// fakePipelines serves the user, their open MRs in each project, most
+// recently updated first, and each MR with its head pipeline.
+func fakePipelines(t *testing.T, projects map[string][]fakeMR) *Settings {
+	t.Helper()
+
+	// The MRs name their project by ID
+	ids := map[string]int{}
+	for project := range projects {
+		ids[project] = len(ids) + 7
+	}
+	mrURL := func(project string, iid int) string {
+		return fmt.Sprintf("https://gitlab.example.com/%s/-/merge_requests/%d", project, iid)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		path := strings.TrimPrefix(r.URL.EscapedPath(), "/api/v4/")
+		if path == "user" {
+			_, _ = w.Write([]byte(`{"id":42,"username":"ada"}`))
+
+			return
+		}
+
+		project, rest, _ := strings.Cut(strings.TrimPrefix(path, "projects/"), "/")
+		project = strings.ReplaceAll(project, "%2F", "/")
+		for name, id := range ids {
+			if project == strconv.Itoa(id) {
+				project = name
+			}
+		}
+		mergeRequests, ok := projects[project]
+		if !ok {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		if rest == "merge_requests" {
+			if got := r.URL.Query().Get("author_id"); got != "42" {
+				t.Errorf("author_id = %q, want 42", got)
+			}
+			var list []map[string]interface{}
+			updated := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+			for i, mergeRequest := range mergeRequests {
+				list = append(list, map[string]interface{}{
+					"iid":        mergeRequest.iid,
+					"project_id": ids[project],
+					"web_url":    mrURL(project, mergeRequest.iid),
+					"updated_at": updated.Add(-time.Duration(i) * time.Hour),
+				})
+			}
+			writeJSON(t, w, list)
+
+			return
+		}
+
+		for _, mergeRequest := range mergeRequests {
+			if rest != fmt.Sprintf("merge_requests/%d", mergeRequest.iid) {
+				continue
+			}
+			full := map[string]interface{}{"iid": mergeRequest.iid, "web_url": mrURL(project, mergeRequest.iid)}
+			if mergeRequest.status != "" {
+				full["head_pipeline"] = map[string]interface{}{"id": mergeRequest.iid, "status": mergeRequest.status}
+			}
+			writeJSON(t, w, full)
+
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	return &Settings{Server: server.URL, PersonalAccessToken: "the-token", Mode: ModePipelines}
+}
+
+func writeJSON(t *testing.T, w http.ResponseWriter, value interface{}) {
+	t.Helper()
+
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPipelineCounts(t *testing.T) {
+	tests := []struct {
+		name          string
+		mergeRequests []fakeMR
+		want          Result
+	}{
+		{
+			name: "pipeline statuses",
+			mergeRequests: []fakeMR{
+				{iid: 1, status: "running"},
+				{iid: 2, status: "failed"},
+				{iid: 3, status: "success"},
+				{iid: 4, status: "pending"},
+				{iid: 5, status: "failed"},
+				{iid: 6, status: "canceled"},
+				{iid: 7, status: "manual"},
+				{iid: 8},
+			},
+			want: Result{
+				FailedPipelines:  2,
+				RunningPipelines: 2,
+				PassedPipelines:  1,
+				// The most recently updated MR whose pipeline failed
+				FailingMRURL: "https://gitlab.example.com/acme/tool/-/merge_requests/2",
+				Rows:         []Row{{Kind: "failed", Count: 2}, {Kind: "running", Count: 2}, {Kind: "passed", Count: 1}},
+				Alert:        true,
+			},
+		},
+		{
+			name: "an empty project",
+			want: Result{
+				Rows:      []Row{{Kind: "failed", Count: 0}, {Kind: "running", Count: 0}, {Kind: "passed", Count: 0}},
+				InboxZero: true,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := fakePipelines(t, map[string][]fakeMR{"acme/tool": tt.mergeRequests})
+			settings.Projects = []string{"acme/tool"}
+
+			got, err := FetchUnseenCount(t.Context(), newIdentityCache(), settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.FailedPipelines != tt.want.FailedPipelines ||
+				got.RunningPipelines != tt.want.RunningPipelines ||
+				got.PassedPipelines != tt.want.PassedPipelines {
+				t.Errorf("failed, running, passed = %d, %d, %d, want %d, %d, %d",
+					got.FailedPipelines, got.RunningPipelines, got.PassedPipelines,
+					tt.want.FailedPipelines, tt.want.RunningPipelines, tt.want.PassedPipelines)
+			}
+			if got.FailingMRURL != tt.want.FailingMRURL {
+				t.Errorf("FailingMRURL = %q, want %q", got.FailingMRURL, tt.want.FailingMRURL)
+			}
+			if !slices.Equal(got.Rows, tt.want.Rows) {
+				t.Errorf("Rows = %v, want %v", got.Rows, tt.want.Rows)
+			}
+			if got.InboxZero != tt.want.InboxZero || got.Alert != tt.want.Alert {
+				t.Errorf("InboxZero, Alert = %v, %v, want %v, %v",
+					got.InboxZero, got.Alert, tt.want.InboxZero, tt.want.Alert)
+			}
+		})
+	}
+}
+
+func TestPipelineStatus(t *testing.T) {
+	tests := map[string]string{
+		"failed":               "failed",
+		"created":              "running",
+		"waiting_for_resource": "running",
+		"preparing":            "running",
+		"pending":              "running",
+		"running":              "running",
+		"scheduled":            "running",
+		"success":              "passed",
+		"canceled":             "",
+		"skipped":              "",
+		"manual":               "",
+		"":                     "",
+	}
+
+	for status, want := range tests {
+		if got := pipelineStatus(status); got != want {
+			t.Errorf("pipelineStatus(%q) = %q, want %q", status, got, want)
+		}
+	}
+}
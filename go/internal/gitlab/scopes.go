@@ -0,0 +1,180 @@
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"ca.michaelabon.inboxes/internal/inbox"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// maxListed is the most groups or projects listed in the property inspector.
+const maxListed = 500
+
+// scope is where to look for issues and MRs: everywhere,
+// a group and its subgroups, or a single project.
+type scope struct {
+	group   string
+	project string
+}
+
+// scopes lists the groups and projects to search, or a single scope for everywhere.
+// Subgroups and projects inside one of the groups are left out,
+// since the group already counts them.
+func (s Settings) scopes() []scope {
+	groups := items(s.Groups)
+	var scopes []scope
+	for _, group := range groups {
+		if !insideAny(group, groups) && !slices.Contains(scopes, scope{group: group}) {
+			scopes = append(scopes, scope{group: group})
+		}
+	}
+
+	for _, project := range items(s.Projects) {
+		if !insideAny(project, groups) && !slices.Contains(scopes, scope{project: project}) {
+			scopes = append(scopes, scope{project: project})
+		}
+	}
+
+	if len(scopes) == 0 {
+		return []scope{{}}
+	}
+
+	return scopes
+}
+
+// insideAny reports whether the full path is inside one of the groups,
+// at any depth.
+func insideAny(path string, groups []string) bool {
+	for _, group := range groups {
+		if strings.HasPrefix(path, group+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// webScope is where the key's links point: a single group or project
+// has its own pages, but several of them need the dashboard.
+func (s Settings) webScope() scope {
+	if scopes := s.scopes(); len(scopes) == 1 {
+		return scopes[0]
+	}
+
+	return scope{}
+}
+
+// apiPath returns the API path of a resource, e.g., "issues", within the scope.
+func (s scope) apiPath(resource string) string {
+	switch {
+	case s.project != "":
+		return "projects/" + gitlab.PathEscape(s.project) + "/" + resource
+	case s.group != "":
+		return "groups/" + gitlab.PathEscape(s.group) + "/" + resource
+	default:
+		return resource
+	}
+}
+
+// webPath returns the path of a list page, e.g., "issues", within the scope.
+func (s scope) webPath(page string) string {
+	switch {
+	case s.project != "":
+		return s.project + "/-/" + page
+	case s.group != "":
+		return "groups/" + s.group + "/-/" + page
+	default:
+		return "dashboard/" + page
+	}
+}
+
+// scopeOption is a group or project as listed in the property inspector.
+type scopeOption struct {
+	Path string `json:"path"`
+	Name string `json:"name"`
+}
+
+// FetchScopes lists the groups and projects the user is a member of,
+// sorted by path, for the property inspector.
+func FetchScopes(ctx context.Context, settings *Settings) (groups, projects []scopeOption, err error) {
+	if settings.PersonalAccessToken == "" {
+		return nil, nil, inbox.NewError(inbox.CategoryConfig, "missing PersonalAccessToken")
+	}
+	if settings.Server == "" {
+		return nil, nil, inbox.NewError(inbox.CategoryConfig, "missing Server")
+	}
+
+	git, err := gitlab.NewClient(settings.PersonalAccessToken, gitlab.WithBaseURL(settings.Server))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error while getting session: %w", err)
+	}
+
+	groupOptions := gitlab.ListGroupsOptions{
+		MinAccessLevel: gitlab.Ptr(gitlab.GuestPermissions),
+	}
+	groups, err = listAll(ctx, func(
+		listOptions gitlab.ListOptions,
+		requestOptions ...gitlab.RequestOptionFunc,
+	) ([]scopeOption, *gitlab.Response, error) {
+		groupOptions.ListOptions = listOptions
+		page, response, err := git.Groups.ListGroups(&groupOptions, requestOptions...)
+		options := make([]scopeOption, 0, len(page))
+		for _, group := range page {
+			options = append(options, scopeOption{Path: group.FullPath, Name: group.FullName})
+		}
+
+		return options, response, err
+	})
+	if err != nil {
+		return nil, nil, categorize(fmt.Errorf("error while listing groups: %w", err))
+	}
+
+	projectOptions := gitlab.ListProjectsOptions{
+		Membership: gitlab.Ptr(true),
+		Archived:   gitlab.Ptr(false),
+		Simple:     gitlab.Ptr(true),
+	}
+	projects, err = listAll(ctx, func(
+		listOptions gitlab.ListOptions,
+		requestOptions ...gitlab.RequestOptionFunc,
+	) ([]scopeOption, *gitlab.Response, error) {
+		projectOptions.ListOptions = listOptions
+		page, response, err := git.Projects.ListProjects(&projectOptions, requestOptions...)
+		options := make([]scopeOption, 0, len(page))
+		for _, project := range page {
+			options = append(options, scopeOption{Path: project.PathWithNamespace, Name: project.NameWithNamespace})
+		}
+
+		return options, response, err
+	})
+	if err != nil {
+		return nil, nil, categorize(fmt.Errorf("error while listing projects: %w", err))
+	}
+
+	return groups, projects, nil
+}
+
+// listAll pages through list, up to maxListed items, sorted by path.
+func listAll(ctx context.Context, list listFunc[scopeOption]) ([]scopeOption, error) {
+	var options []scopeOption
+	for page := int64(1); len(options) < maxListed; page++ {
+		items, response, err := list(gitlab.ListOptions{Page: page, PerPage: maxPerPage}, gitlab.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, items...)
+
+		if response.NextPage == 0 {
+			break
+		}
+	}
+
+	slices.SortFunc(options, func(a, b scopeOption) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+
+	return options, nil
+}
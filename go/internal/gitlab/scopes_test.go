@@ -0,0 +1,57 @@
+package gitlab
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestScopes(t *testing.T) {
+	tests := []struct {
+		name     string
+		groups   []string
+		projects []string
+		want     []scope
+	}{
+		{
+			name: "everywhere",
+			want: []scope{{}},
+		},
+		{
+			name:     "groups and projects",
+			groups:   []string{"acme"},
+			projects: []string{"other/tool"},
+			want:     []scope{{group: "acme"}, {project: "other/tool"}},
+		},
+		{
+			name:     "projects inside a group are left out",
+			groups:   []string{"acme"},
+			projects: []string{"acme/deep/tool", "acme-labs/tool"},
+			want:     []scope{{group: "acme"}, {project: "acme-labs/tool"}},
+		},
+		{
+			name:   "subgroups of a group are left out, whatever the order",
+			groups: []string{"acme/web/frontend", "acme", "acme/web", "acme-labs"},
+			want:   []scope{{group: "acme"}, {group: "acme-labs"}},
+		},
+		{
+			name:     "duplicates are searched once",
+			groups:   []string{"acme", "acme"},
+			projects: []string{"other/tool", "other/tool"},
+			want:     []scope{{group: "acme"}, {project: "other/tool"}},
+		},
+		{
+			name:   "comma-separated text",
+			groups: []string{" acme/web , acme ,"},
+			want:   []scope{{group: "acme"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := Settings{Groups: tt.groups, Projects: tt.projects}
+			if got := settings.scopes(); !slices.Equal(got, tt.want) {
+				t.Errorf("scopes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -7,6 +7,9 @@
 	"fmt"
 	"log"
 	"net/url"
+	"slices"
+	"strings"
+	"text/template"
 	"time"
 
 	"ca.michaelabon.inboxes/internal/display"
@@ -15,13 +18,75 @@
 )
 
 //go:embed gitlab_button_default.svg
-var svgTemplate string
+var svgSource string
+
+// keySize is the width and height of the key image.
+const keySize = 400
+
+// maxFontSize is the size of the counts when the rows are tall enough.
+const maxFontSize = 96
+
+type svgData struct {
+	FontSize int
+	Alert    bool
+	Rows     []svgRow
+}
+
+type svgRow struct {
+	Row
+	Y        int
+	Height   int
+	Baseline int
+}
+
+// renderSVG stacks the rows down the left edge of the key, sharing its height.
+// An alert turns the background red.
+func (s Service) renderSVG(rows []Row, alert bool) (string, error) {
+	data := svgData{FontSize: maxFontSize, Alert: alert}
+	if len(rows) > 0 {
+		height := keySize / len(rows)
+		data.FontSize = min(maxFontSize, height*4/5)
+		for i, row := range rows {
+			y := i * height
+			data.Rows = append(data.Rows, svgRow{
+				Row:    row,
+				Y:      y,
+				Height: height,
+				// Centre the digits, whose height is about 70% of the font size
+				Baseline: y + height/2 + data.FontSize*7/20,
+			})
+		}
+	}
+
+	var svg strings.Builder
+	if err := s.svg.Execute(&svg, data); err != nil {
+		return "", fmt.Errorf("error while filling svg template: %w", err)
+	}
+
+	return svg.String(), nil
+}
 
 // Service implements inbox.Service for GitLab.
-type Service struct{}
+// Use NewService, which parses its key image template
+// and sets up its identity cache.
+type Service struct {
+	svg        *template.Template
+	identities *identityCache
+}
 
-// Compile-time check that Service implements the interface.
-var _ inbox.Service[*Settings, Result] = Service{}
+func NewService() Service {
+	return Service{
+		svg:        template.Must(template.New("gitlab").Parse(svgSource)),
+		identities: newIdentityCache(),
+	}
+}
+
+// Compile-time check that Service implements the interfaces.
+var (
+	_ inbox.Service[*Settings, Result]     = Service{}
+	_ inbox.MinRefreshIntervalProvider     = Service{}
+	_ inbox.SendToPluginHandler[*Settings] = Service{}
+)
 
 func (s Service) ActionUUID() string {
 	return "ca.michaelabon.streamdeck-inboxes.gitlab.action"
@@ -31,6 +96,10 @@ func (s Service) RefreshInterval() time.Duration {
 	return RefreshInterval
 }
 
+func (s Service) MinRefreshInterval() time.Duration {
+	return MinRefreshInterval
+}
+
 func (s Service) LogPrefix() string {
 	return "[gitlab]"
 }
@@ -45,7 +114,7 @@ func (s Service) ParseSettings(raw json.RawMessage) (*Settings, error) {
 }
 
 func (s Service) FetchResult(ctx context.Context, settings *Settings) (Result, error) {
-	return FetchUnseenCount(settings)
+	return FetchUnseenCount(ctx, s.identities, settings)
 }
 
 func (s Service) Render(
@@ -54,46 +123,34 @@ func (s Service) Render(
 	result Result,
 	err error,
 ) error {
-	if err != nil {
-		newErr := client.SetTitle(ctx, display.PadRight("!"), streamdeck.HardwareAndSoftware)
-		if newErr != nil {
-			return fmt.Errorf("error setting title: %w  -- %w", newErr, err)
-		}
-
-		newErr = client.SetState(ctx, inbox.DefaultState)
-		if newErr != nil {
-			return fmt.Errorf("error setting state: %w  -- %w", newErr, err)
-		}
-
-		newErr = client.SetImage(ctx, "", streamdeck.HardwareAndSoftware)
+	if err != nil && !inbox.IsStale(err) {
+		newErr := client.SetImage(ctx, "", streamdeck.HardwareAndSoftware)
 		if newErr != nil {
 			return fmt.Errorf("error setting blank image: %w  -- %w", newErr, err)
 		}
 
-		return err
+		return inbox.RenderError(ctx, client, err)
 	}
 
-	total := result.ToDos + result.AssignedMRs + result.ReviewMRs + result.AssignedIssues
-	if total == 0 {
+	if result.InboxZero {
 		_ = client.SetState(ctx, inbox.GoldState)
 	} else {
 		_ = client.SetState(ctx, inbox.DefaultState)
 	}
 
-	newErr := client.SetTitle(ctx, "", streamdeck.HardwareAndSoftware)
+	title := ""
+	if inbox.IsStale(err) {
+		title = display.PadRight(inbox.StaleMarker)
+	}
+	newErr := client.SetTitle(ctx, title, streamdeck.HardwareAndSoftware)
 	if newErr != nil {
 		return fmt.Errorf("error setting title: %w", newErr)
 	}
 
-	filledSvg := fmt.Sprintf(
-		svgTemplate,
-		result.AssignedIssues,
-		result.AssignedIssues,
-		result.AssignedMRs+result.ReviewMRs,
-		result.AssignedMRs+result.ReviewMRs,
-		result.ToDos,
-		result.ToDos,
-	)
+	filledSvg, svgErr := s.renderSVG(result.Rows, result.Alert)
+	if svgErr != nil {
+		return svgErr
+	}
 
 	setErr := client.SetImage(ctx, display.EncodeSVG(filledSvg), streamdeck.HardwareAndSoftware)
 	if setErr != nil {
@@ -105,6 +162,8 @@ func (s Service) Render(
 	return nil
 }
 
+// OpenURL opens the first shown category with anything in it:
+// to-dos, then review MRs, assigned MRs and issues.
 func (s Service) OpenURL(settings *Settings, result Result) string {
 	if settings.Server == "" {
 		return ""
@@ -115,30 +174,101 @@ func (s Service) OpenURL(settings *Settings, result Result) string {
 		return settings.Server
 	}
 
+	shown := settings.shownCategories()
+	has := func(category Category) bool {
+		return slices.Contains(shown, category) && result.count(category) > 0
+	}
+
+	// Fetching the count finds out who the user is, so this only misses
+	// if the key is pressed before the first fetch finishes
+	user, known := s.identities.cached(settings)
+	mine := func(category Category) bool {
+		return known && has(category)
+	}
+
+	if settings.Mode == ModePipelines && (known || result.FailingMRURL != "") {
+		return pipelinesURL(gitlabURL, settings, user, result)
+	}
+
+	webScope := settings.webScope()
+
+	query := url.Values{}
 	switch {
-	case result.ToDos > 0:
+	case has(CategoryTodos):
 		gitlabURL = gitlabURL.JoinPath("/dashboard/todos")
-	case result.ReviewMRs > 0:
-		gitlabURL = gitlabURL.JoinPath("/dashboard/merge_requests")
-		query := gitlabURL.Query()
-		query.Set("reviewer_username", settings.Username)
-		gitlabURL.RawQuery = query.Encode()
-	case result.AssignedMRs > 0:
-		gitlabURL = gitlabURL.JoinPath("/dashboard/merge_requests")
-		query := gitlabURL.Query()
-		query.Set("assignee_username", settings.Username)
-		gitlabURL.RawQuery = query.Encode()
-	case result.AssignedIssues > 0:
-		gitlabURL = gitlabURL.JoinPath("/dashboard/issues")
-		query := gitlabURL.Query()
+	case mine(CategoryReviewMRs):
+		gitlabURL = gitlabURL.JoinPath(webScope.webPath("merge_requests"))
+		query.Set("reviewer_username", user.Username)
+		settings.addFilters(query, true)
+	case mine(CategoryAssignedMRs):
+		gitlabURL = gitlabURL.JoinPath(webScope.webPath("merge_requests"))
+		query.Set("assignee_username", user.Username)
+		settings.addFilters(query, true)
+	case mine(CategoryIssues):
+		gitlabURL = gitlabURL.JoinPath(webScope.webPath("issues"))
 		query.Set("state", "opened")
-		query.Set("assignee_username[]", settings.Username)
-		gitlabURL.RawQuery = query.Encode()
+		query.Set("assignee_username[]", user.Username)
+		settings.addFilters(query, false)
 	default:
 		gitlabURL = gitlabURL.JoinPath("/dashboard/projects/starred")
 	}
+	if len(query) > 0 {
+		gitlabURL.RawQuery = query.Encode()
+	}
 
 	log.Printf("[gitlab] Generated URL: %s\n", gitlabURL.String())
 
 	return gitlabURL.String()
 }
+
+// HandleSendToPlugin processes messages from the property inspector.
+func (s Service) HandleSendToPlugin(
+	ctx context.Context,
+	client *streamdeck.Client,
+	payload json.RawMessage,
+	settings *Settings,
+) (interface{}, error) {
+	var request struct {
+		Action string `json:"action"`
+	}
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return nil, err
+	}
+
+	switch request.Action {
+	case "validateToken":
+		info, err := ValidateToken(ctx, s.identities, settings)
+		if err != nil {
+			// Return error as payload to PI, not as Go error
+			//nolint:nilerr // intentionally returning nil error with error payload
+			return map[string]interface{}{
+				"action": "validateToken",
+				"error":  err.Error(),
+			}, nil
+		}
+
+		return map[string]interface{}{
+			"action": "validateToken",
+			"token":  info,
+		}, nil
+	case "fetchScopes":
+		groups, projects, err := FetchScopes(ctx, settings)
+		if err != nil {
+			// Return error as payload to PI, not as Go error
+			//nolint:nilerr // intentionally returning nil error with error payload
+			return map[string]interface{}{
+				"action": "fetchScopes",
+				"error":  err.Error(),
+			}, nil
+		}
+
+		return map[string]interface{}{
+			"action":   "fetchScopes",
+			"groups":   groups,
+			"projects": projects,
+		}, nil
+	default:
+		//nolint:nilnil // unknown actions are intentionally ignored
+		return nil, nil
+	}
+}
@@ -1,126 +1,170 @@
 package gmail
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"log"
-	"sort"
 	"time"
 
-	"github.com/emersion/go-imap"
+	"ca.michaelabon.inboxes/internal/imapx"
+	"ca.michaelabon.inboxes/internal/inbox"
 	"github.com/emersion/go-imap/client"
 )
 
 // DefaultMailbox is the default Gmail mailbox to monitor.
-const DefaultMailbox = "INBOX"
+const DefaultMailbox = imapx.DefaultMailbox
 
-// mailboxChannelBuffer is the buffer size for the mailbox listing channel.
-const mailboxChannelBuffer = 100
+// gmailHost is Gmail's IMAP server, which only takes implicit TLS.
+const gmailHost = "imap.gmail.com"
 
 type Settings struct {
 	Username string
 	Password string
-	Label    string // Gmail label/mailbox to monitor (default: "INBOX")
+	Label    string                  // Deprecated: single label kept for older keys; see Labels
+	Labels   inbox.OneOrMany[string] // Gmail labels/mailboxes to monitor (default: "INBOX")
+
+	// AuthMethod is AuthMethodPassword (default) or AuthMethodOAuth.
+	AuthMethod   string
+	ClientID     string
+	ClientSecret string
+	RefreshToken string
+	// AuthURL and TokenURL override Google's OAuth2 endpoints.
+	AuthURL  string
+	TokenURL string
+
+	// DoublePress is DoublePressMarkRead to mark the labels as read on a double press.
+	// By default, a double press opens the label like a short press.
+	DoublePress string
 }
 
-func FetchUnseenCount(settings Settings) (uint, error) {
+// DoublePressMarkRead marks every monitored label as read on a double press.
+// It can't be undone, so keys only do it when asked to.
+const DoublePressMarkRead = "markRead"
+
+// checkCredentials reports which setting is missing for the chosen auth method.
+func checkCredentials(settings Settings) error {
 	if settings.Username == "" {
-		return 0, errors.New("missing Username")
+		return inbox.NewError(inbox.CategoryConfig, "missing Username")
 	}
-	if settings.Password == "" {
-		return 0, errors.New("missing Password")
+
+	if !settings.usesOAuth() {
+		if settings.Password == "" {
+			return inbox.NewError(inbox.CategoryConfig, "missing Password")
+		}
+
+		return nil
 	}
 
-	return getUnseenCount(settings)
+	if settings.ClientID == "" {
+		return inbox.NewError(inbox.CategoryConfig, "missing Client ID")
+	}
+	if settings.RefreshToken == "" {
+		return inbox.NewError(inbox.CategoryConfig, "not signed in with Google")
+	}
+
+	return nil
 }
 
-func getUnseenCount(settings Settings) (uint, error) {
-	username := settings.Username
-	password := settings.Password
+func FetchUnseenCount(ctx context.Context, tokens *tokenCache, settings Settings) (Result, error) {
+	if err := checkCredentials(settings); err != nil {
+		return Result{}, err
+	}
 
-	c, err := client.DialTLS("imap.gmail.com:993", nil)
+	return getUnseenCount(ctx, tokens, settings)
+}
+
+// dial connects and logs in to Gmail, with a password or XOAUTH2.
+// The returned function logs out and must always be called.
+func dial(ctx context.Context, tokens *tokenCache, settings Settings) (*client.Client, func(), error) {
+	server := imapx.Server{Host: gmailHost, TLS: imapx.TLSImplicit}
+
+	return imapx.Dial(ctx, server, func(c *client.Client) error {
+		return authenticate(ctx, c, tokens, settings)
+	}, "[gmail]")
+}
+
+// authenticate logs in with the password, or with XOAUTH2 when configured.
+func authenticate(ctx context.Context, c *client.Client, tokens *tokenCache, settings Settings) error {
+	if !settings.usesOAuth() {
+		return imapx.PasswordLogin(settings.Username, settings.Password)(c)
+	}
+
+	token, err := tokens.accessToken(ctx, settings)
 	if err != nil {
-		return 0, fmt.Errorf("error while dialing the server: %w", err)
+		return err
 	}
 
-	// Don't forget to logout
-	defer func(c *client.Client) {
-		err := c.Logout()
-		if err != nil {
-			log.Println("[gmail]", "unable to close imapClient", err)
-		}
-	}(c)
+	if err := c.Authenticate(&xoauth2Client{username: settings.Username, accessToken: token}); err != nil {
+		// The token may have been revoked early; fetch a new one next time
+		tokens.forget(settings)
 
-	if err := c.Login(username, password); err != nil {
-		return 0, fmt.Errorf("error during login: %w", err)
+		return inbox.WrapError(inbox.CategoryAuth, fmt.Errorf("error during XOAUTH2 authentication: %w", err))
 	}
 
-	// Default to INBOX if no label specified
-	mailboxName := settings.Label
-	if mailboxName == "" {
-		mailboxName = DefaultMailbox
-	}
+	return nil
+}
 
-	status, err := c.Status(mailboxName, []imap.StatusItem{imap.StatusUnseen})
+// getUnseenCount asks for the STATUS of every monitored label
+// over a single IMAP session.
+func getUnseenCount(ctx context.Context, tokens *tokenCache, settings Settings) (Result, error) {
+	c, closeFn, err := dial(ctx, tokens, settings)
 	if err != nil {
-		return 0, fmt.Errorf("unable to get status of %s: %w", mailboxName, err)
+		return Result{}, err
 	}
+	defer closeFn()
 
-	return uint(status.Unseen), nil
+	var result Result
+	for _, label := range settings.monitoredLabels() {
+		unseen, err := imapx.UnseenCount(c, label)
+		if err != nil {
+			return Result{}, err
+		}
+		result.Labels = append(result.Labels, LabelCount{Label: label, Unseen: unseen})
+	}
+
+	return result, nil
 }
 
-// FetchLabels returns all available Gmail mailbox names (labels).
-func FetchLabels(settings Settings) ([]string, error) {
-	if settings.Username == "" {
-		return nil, errors.New("missing Username")
-	}
-	if settings.Password == "" {
-		return nil, errors.New("missing Password")
+// MarkAllRead flags every unseen message in the monitored labels as seen.
+func MarkAllRead(ctx context.Context, tokens *tokenCache, settings Settings) error {
+	if err := checkCredentials(settings); err != nil {
+		return err
 	}
 
-	c, err := client.DialTLS("imap.gmail.com:993", nil)
+	c, closeFn, err := dial(ctx, tokens, settings)
 	if err != nil {
-		return nil, fmt.Errorf("error while dialing the server: %w", err)
+		return err
 	}
+	defer closeFn()
 
-	defer func(c *client.Client) {
-		err := c.Logout()
+	for _, label := range settings.monitoredLabels() {
+		marked, err := imapx.MarkAllRead(c, label)
 		if err != nil {
-			log.Println("[gmail]", "unable to close imapClient", err)
+			return err
 		}
-	}(c)
 
-	if err := c.Login(settings.Username, settings.Password); err != nil {
-		return nil, fmt.Errorf("error during login: %w", err)
+		log.Println("[gmail]", "marked", marked, "messages as read in", label)
 	}
 
-	// List all mailboxes
-	mailboxes := make(chan *imap.MailboxInfo, mailboxChannelBuffer)
-	done := make(chan error, 1)
-	go func() {
-		done <- c.List("", "*", mailboxes)
-	}()
-
-	var labels []string
-	for m := range mailboxes {
-		labels = append(labels, m.Name)
-	}
+	return nil
+}
 
-	if err := <-done; err != nil {
-		return nil, fmt.Errorf("error listing mailboxes: %w", err)
+// FetchLabels returns all available Gmail mailbox names (labels).
+func FetchLabels(ctx context.Context, tokens *tokenCache, settings Settings) ([]string, error) {
+	if err := checkCredentials(settings); err != nil {
+		return nil, err
 	}
 
-	// Sort labels alphabetically, but put INBOX first
-	sort.Strings(labels)
-	for i, label := range labels {
-		if label == DefaultMailbox && i > 0 {
-			labels = append([]string{DefaultMailbox}, append(labels[:i], labels[i+1:]...)...)
-
-			break
-		}
+	c, closeFn, err := dial(ctx, tokens, settings)
+	if err != nil {
+		return nil, err
 	}
+	defer closeFn()
 
-	return labels, nil
+	return imapx.ListMailboxes(c)
 }
 
 const RefreshInterval = time.Minute
+
+// MinRefreshInterval protects the account: every refresh logs in over IMAP.
+const MinRefreshInterval = 30 * time.Second
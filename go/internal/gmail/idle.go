@@ -0,0 +1,38 @@
+package gmail
+
+import (
+	"context"
+
+	"ca.michaelabon.inboxes/internal/imapx"
+	"ca.michaelabon.inboxes/internal/inbox"
+)
+
+// WatchUnseenCount keeps one IMAP connection open on the monitored label
+// and pushes its unseen count whenever it changes. See imapx.Watch.
+// IDLE only reports changes to the selected mailbox, so keys that monitor
+// several labels are polled instead.
+func WatchUnseenCount(
+	ctx context.Context,
+	tokens *tokenCache,
+	settings Settings,
+	push func(Result, error),
+) error {
+	if err := checkCredentials(settings); err != nil {
+		return err
+	}
+
+	labels := settings.monitoredLabels()
+	if len(labels) > 1 {
+		return inbox.ErrPushUnsupported
+	}
+
+	c, closeFn, err := dial(ctx, tokens, settings)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	return imapx.Watch(ctx, c, labels[0], func(unseen uint) {
+		push(Result{Labels: []LabelCount{{Label: labels[0], Unseen: unseen}}}, nil)
+	})
+}
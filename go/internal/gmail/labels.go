@@ -0,0 +1,62 @@
+package gmail
+
+// monitoredLabels returns the labels a key watches, without blanks or
+// duplicates: Labels if any were chosen, else the older single Label,
+// else DefaultMailbox.
+func (s Settings) monitoredLabels() []string {
+	var labels []string
+	seen := map[string]bool{}
+	for _, label := range s.Labels {
+		if label == "" || seen[label] {
+			continue
+		}
+		seen[label] = true
+		labels = append(labels, label)
+	}
+
+	if len(labels) > 0 {
+		return labels
+	}
+	if s.Label != "" {
+		return []string{s.Label}
+	}
+
+	return []string{DefaultMailbox}
+}
+
+// LabelCount is the unseen count of one label.
+type LabelCount struct {
+	Label  string
+	Unseen uint
+}
+
+// Result holds the unseen count of each monitored label, in settings order.
+type Result struct {
+	Labels []LabelCount
+}
+
+// Total is the combined unseen count shown on the key.
+// A message with several of the labels is counted once per label.
+func (r Result) Total() uint {
+	var total uint
+	for _, label := range r.Labels {
+		total += label.Unseen
+	}
+
+	return total
+}
+
+// Busiest returns the label with the most unseen mail, the first one on a
+// tie, or "" if none has any.
+func (r Result) Busiest() string {
+	busiest := ""
+	var most uint
+	for _, label := range r.Labels {
+		if label.Unseen > most {
+			busiest = label.Label
+			most = label.Unseen
+		}
+	}
+
+	return busiest
+}
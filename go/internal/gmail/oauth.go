@@ -0,0 +1,257 @@
+package gmail
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"ca.michaelabon.inboxes/internal/imapx"
+	"ca.michaelabon.inboxes/internal/inbox"
+	"github.com/emersion/go-sasl"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// AuthMethodPassword logs in with the address and an app password. This is the default.
+	AuthMethodPassword = "password"
+	// AuthMethodOAuth authenticates with SASL XOAUTH2, using an access token
+	// obtained from the stored refresh token.
+	AuthMethodOAuth = "oauth"
+)
+
+// Google's OAuth2 endpoints. Settings may override them,
+// e.g., to point at a local fake token endpoint.
+const (
+	DefaultAuthURL  = "https://accounts.google.com/o/oauth2/auth"
+	DefaultTokenURL = "https://oauth2.googleapis.com/token"
+)
+
+// mailScope is the only scope that grants IMAP access.
+const mailScope = "https://mail.google.com/"
+
+// AuthorizeTimeout is how long the loopback redirect waits for the user
+// to finish signing in.
+const AuthorizeTimeout = 5 * time.Minute
+
+// callbackPath is where the browser is redirected after signing in.
+const callbackPath = "/callback"
+
+// usesOAuth reports whether the settings ask for XOAUTH2 instead of a password.
+func (s Settings) usesOAuth() bool {
+	return s.AuthMethod == AuthMethodOAuth
+}
+
+// oauthConfig builds the OAuth2 client configuration from the settings.
+func (s Settings) oauthConfig(redirectURL string) *oauth2.Config {
+	authURL := s.AuthURL
+	if authURL == "" {
+		authURL = DefaultAuthURL
+	}
+	tokenURL := s.TokenURL
+	if tokenURL == "" {
+		tokenURL = DefaultTokenURL
+	}
+
+	return &oauth2.Config{
+		ClientID:     s.ClientID,
+		ClientSecret: s.ClientSecret,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  authURL,
+			TokenURL: tokenURL,
+		},
+		RedirectURL: redirectURL,
+		Scopes:      []string{mailScope},
+	}
+}
+
+// xoauth2Client implements the SASL XOAUTH2 mechanism used by Gmail.
+// See https://developers.google.com/gmail/imap/xoauth2-protocol
+type xoauth2Client struct {
+	username    string
+	accessToken string
+}
+
+var _ sasl.Client = (*xoauth2Client)(nil)
+
+func (a *xoauth2Client) Start() (string, []byte, error) {
+	ir := "user=" + a.username + "\x01auth=Bearer " + a.accessToken + "\x01\x01"
+
+	return "XOAUTH2", []byte(ir), nil
+}
+
+// Next answers the server's error challenge with an empty response,
+// as the protocol requires, so that the server can finish with a NO.
+func (a *xoauth2Client) Next(challenge []byte) ([]byte, error) {
+	log.Println("[gmail]", "XOAUTH2 rejected:", string(challenge))
+
+	return []byte{}, nil
+}
+
+// tokenCache caches access tokens by client and refresh token,
+// so each poll doesn't hit the token endpoint.
+type tokenCache struct {
+	mu     sync.Mutex
+	tokens map[string]*oauth2.Token
+}
+
+func newTokenCache() *tokenCache {
+	return &tokenCache{tokens: map[string]*oauth2.Token{}}
+}
+
+func tokenCacheKey(settings Settings) string {
+	return settings.TokenURL + "\x00" + settings.ClientID + "\x00" + settings.RefreshToken
+}
+
+// accessToken returns a valid access token for the settings,
+// exchanging the refresh token for a new one when needed.
+func (c *tokenCache) accessToken(ctx context.Context, settings Settings) (string, error) {
+	key := tokenCacheKey(settings)
+
+	c.mu.Lock()
+	cached := c.tokens[key]
+	c.mu.Unlock()
+	if cached.Valid() {
+		return cached.AccessToken, nil
+	}
+
+	config := settings.oauthConfig("")
+	token, err := config.TokenSource(ctx, &oauth2.Token{RefreshToken: settings.RefreshToken}).Token()
+	if err != nil {
+		return "", categorizeTokenError(fmt.Errorf("error while refreshing the access token: %w", err))
+	}
+
+	c.mu.Lock()
+	c.tokens[key] = token
+	c.mu.Unlock()
+
+	return token.AccessToken, nil
+}
+
+// forget drops a cached access token that the server rejected.
+func (c *tokenCache) forget(settings Settings) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.tokens, tokenCacheKey(settings))
+}
+
+// categorizeTokenError tells a revoked or invalid grant apart from
+// a token endpoint that is having problems.
+func categorizeTokenError(err error) error {
+	var retrieveErr *oauth2.RetrieveError
+	if !errors.As(err, &retrieveErr) || retrieveErr.Response == nil {
+		return err
+	}
+
+	if retrieveErr.Response.StatusCode >= http.StatusInternalServerError {
+		return inbox.WrapError(inbox.CategoryUpstream, err)
+	}
+
+	return inbox.WrapError(inbox.CategoryAuth, err)
+}
+
+// Authorize runs the OAuth2 authorization code flow with a loopback redirect.
+// It listens on a random port of 127.0.0.1, asks openBrowser to show Google's
+// consent page, and waits for the redirect until ctx is done.
+// The returned token carries the refresh token to store in the settings.
+func Authorize(ctx context.Context, settings Settings, openBrowser func(authURL string) error) (*oauth2.Token, error) {
+	if settings.ClientID == "" {
+		return nil, inbox.NewError(inbox.CategoryConfig, "missing Client ID")
+	}
+
+	var listenConfig net.ListenConfig
+	listener, err := listenConfig.Listen(ctx, "tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("error while listening for the redirect: %w", err)
+	}
+
+	redirectURL := fmt.Sprintf("http://%s%s", listener.Addr().String(), callbackPath)
+	config := settings.oauthConfig(redirectURL)
+
+	state := rand.Text()
+	verifier := oauth2.GenerateVerifier()
+
+	codes := make(chan string, 1)
+	failures := make(chan error, 1)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(callbackPath, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch {
+		case query.Get("state") != state:
+			http.Error(w, "Unexpected state. Please try signing in again.", http.StatusBadRequest)
+
+			return
+		case query.Get("error") != "":
+			http.Error(w, "Sign-in failed: "+query.Get("error"), http.StatusBadRequest)
+			select {
+			case failures <- inbox.NewError(inbox.CategoryAuth, "authorization denied: "+query.Get("error")):
+			default:
+			}
+
+			return
+		case query.Get("code") == "":
+			http.Error(w, "Missing authorization code.", http.StatusBadRequest)
+
+			return
+		}
+
+		_, _ = fmt.Fprintln(w, "Signed in. You can close this window and return to the Stream Deck.")
+		select {
+		case codes <- query.Get("code"):
+		default:
+		}
+	})
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: imapx.CommandTimeout,
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Println("[gmail]", "loopback server error", err)
+		}
+	}()
+	defer func() {
+		if err := server.Close(); err != nil {
+			log.Println("[gmail]", "unable to close loopback server", err)
+		}
+	}()
+
+	authURL := config.AuthCodeURL(
+		state,
+		oauth2.AccessTypeOffline,
+		// Without consent, Google only returns a refresh token the first time
+		oauth2.ApprovalForce,
+		oauth2.S256ChallengeOption(verifier),
+		oauth2.SetAuthURLParam("login_hint", settings.Username),
+	)
+	if err := openBrowser(authURL); err != nil {
+		return nil, fmt.Errorf("error while opening the browser: %w", err)
+	}
+
+	var code string
+	select {
+	case code = <-codes:
+	case err := <-failures:
+		return nil, err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("error while waiting for sign-in: %w", ctx.Err())
+	}
+
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, categorizeTokenError(fmt.Errorf("error while exchanging the authorization code: %w", err))
+	}
+	if token.RefreshToken == "" {
+		return nil, inbox.NewError(inbox.CategoryAuth, "no refresh token was returned")
+	}
+
+	return token, nil
+}
@@ -0,0 +1,300 @@
+package gmail
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"ca.michaelabon.inboxes/internal/inbox"
+)
+
+// fakeTokenEndpoint is a local OAuth2 token endpoint.
+// It exchanges one authorization code, checking the PKCE verifier
+// against the challenge the browser was sent, and refreshes tokens.
+type fakeTokenEndpoint struct {
+	*httptest.Server
+
+	code string
+	// expiresIn is the lifetime of the access tokens it hands out, in seconds.
+	expiresIn int
+	// status, if set, is returned instead of a token.
+	status int
+
+	mu        sync.Mutex
+	challenge string
+	requests  []url.Values
+}
+
+func newFakeTokenEndpoint(t *testing.T) *fakeTokenEndpoint {
+	t.Helper()
+
+	endpoint := &fakeTokenEndpoint{code: "the-code", expiresIn: 3600}
+	endpoint.Server = httptest.NewServer(http.HandlerFunc(endpoint.serveToken))
+	t.Cleanup(endpoint.Close)
+
+	return endpoint
+}
+
+func (f *fakeTokenEndpoint) serveToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r.PostForm)
+
+	if f.status != 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(f.status)
+		_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if r.PostForm.Get("code") != f.code ||
+			base64.RawURLEncoding.EncodeToString(verifier[:]) != f.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+
+			return
+		}
+		f.writeToken(w, "access-1", "the-refresh-token")
+	case "refresh_token":
+		f.writeToken(w, "access-for-"+r.PostForm.Get("refresh_token"), "")
+	default:
+		http.Error(w, `{"error":"unsupported_grant_type"}`, http.StatusBadRequest)
+	}
+}
+
+func (f *fakeTokenEndpoint) writeToken(w http.ResponseWriter, accessToken, refreshToken string) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    f.expiresIn,
+	})
+}
+
+func (f *fakeTokenEndpoint) setChallenge(challenge string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.challenge = challenge
+}
+
+func (f *fakeTokenEndpoint) grants() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	grants := make([]string, 0, len(f.requests))
+	for _, request := range f.requests {
+		grants = append(grants, request.Get("grant_type"))
+	}
+
+	return grants
+}
+
+func oauthSettings(endpoint *fakeTokenEndpoint) Settings {
+	return Settings{
+		Username:     "me@example.com",
+		AuthMethod:   AuthMethodOAuth,
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		RefreshToken: "the-refresh-token",
+		AuthURL:      "https://accounts.example.com/auth",
+		TokenURL:     endpoint.URL,
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	endpoint := newFakeTokenEndpoint(t)
+	settings := oauthSettings(endpoint)
+	settings.RefreshToken = ""
+
+	token, err := Authorize(t.Context(), settings, func(authURL string) error {
+		consent, err := url.Parse(authURL)
+		if err != nil {
+			return err
+		}
+		query := consent.Query()
+		for name, want := range map[string]string{
+			"client_id":             "client-id",
+			"access_type":           "offline",
+			"prompt":                "consent",
+			"code_challenge_method": "S256",
+			"scope":                 mailScope,
+			"login_hint":            "me@example.com",
+		} {
+			if got := query.Get(name); got != want {
+				t.Errorf("%s = %q, want %q", name, got, want)
+			}
+		}
+		endpoint.setChallenge(query.Get("code_challenge"))
+
+		redirect := query.Get("redirect_uri")
+		if !strings.HasPrefix(redirect, "http://127.0.0.1:") {
+			t.Errorf("redirect_uri = %q, want a loopback address", redirect)
+		}
+
+		// A redirect that doesn't carry our state is turned away
+		forged, err := http.Get(redirect + "?code=forged&state=forged")
+		if err != nil {
+			return err
+		}
+		_ = forged.Body.Close()
+		if forged.StatusCode != http.StatusBadRequest {
+			t.Errorf("a forged redirect got status %d, want 400", forged.StatusCode)
+		}
+
+		// The browser comes back with the code
+		callback, err := http.Get(redirect + "?" + url.Values{
+			"code":  {endpoint.code},
+			"state": {query.Get("state")},
+		}.Encode())
+		if err != nil {
+			return err
+		}
+		_ = callback.Body.Close()
+		if callback.StatusCode != http.StatusOK {
+			t.Errorf("the redirect got status %d, want 200", callback.StatusCode)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token.RefreshToken != "the-refresh-token" {
+		t.Errorf("RefreshToken = %q, want the-refresh-token", token.RefreshToken)
+	}
+	if grants := endpoint.grants(); len(grants) != 1 || grants[0] != "authorization_code" {
+		t.Errorf("token requests = %v, want one authorization_code", grants)
+	}
+}
+
+func TestAuthorizeWrongVerifier(t *testing.T) {
+	endpoint := newFakeTokenEndpoint(t)
+	settings := oauthSettings(endpoint)
+
+	_, err := Authorize(t.Context(), settings, func(authURL string) error {
+		consent, err := url.Parse(authURL)
+		if err != nil {
+			return err
+		}
+		query := consent.Query()
+		// The token endpoint expects a different challenge
+		endpoint.setChallenge("another-challenge")
+
+		callback, err := http.Get(query.Get("redirect_uri") + "?" + url.Values{
+			"code":  {endpoint.code},
+			"state": {query.Get("state")},
+		}.Encode())
+		if err != nil {
+			return err
+		}
+
+		return callback.Body.Close()
+	})
+	if inbox.Categorize(err) != inbox.CategoryAuth {
+		t.Errorf("error = %v, want an auth error", err)
+	}
+}
+
+func TestAccessToken(t *testing.T) {
+	tests := []struct {
+		name      string
+		expiresIn int
+		status    int
+		// forget drops the token between the two calls
+		forget bool
+
+		wantToken string
+		// wantRefresh is how many refreshes to expect, if it matters
+		wantRefresh  int
+		wantCategory inbox.ErrorCategory
+	}{
+		{
+			name:        "reuses the access token while it is valid",
+			expiresIn:   3600,
+			wantToken:   "access-for-the-refresh-token",
+			wantRefresh: 1,
+		},
+		{
+			name: "refreshes an expired access token",
+			// Tokens that expire within oauth2's early expiry margin are already invalid
+			expiresIn:   1,
+			wantToken:   "access-for-the-refresh-token",
+			wantRefresh: 2,
+		},
+		{
+			name:        "refreshes a forgotten access token",
+			expiresIn:   3600,
+			forget:      true,
+			wantToken:   "access-for-the-refresh-token",
+			wantRefresh: 2,
+		},
+		{
+			name:         "a revoked refresh token is an auth error",
+			status:       http.StatusBadRequest,
+			wantCategory: inbox.CategoryAuth,
+		},
+		{
+			name:         "a failing token endpoint is an upstream error",
+			status:       http.StatusServiceUnavailable,
+			wantCategory: inbox.CategoryUpstream,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoint := newFakeTokenEndpoint(t)
+			endpoint.expiresIn = tt.expiresIn
+			endpoint.status = tt.status
+			settings := oauthSettings(endpoint)
+			tokens := newTokenCache()
+
+			for call := range 2 {
+				token, err := tokens.accessToken(t.Context(), settings)
+				if tt.wantCategory != inbox.CategoryUnknown {
+					if got := inbox.Categorize(err); got != tt.wantCategory {
+						t.Errorf("call %d: category = %v, want %v (%v)", call, got, tt.wantCategory, err)
+					}
+
+					continue
+				}
+				if err != nil {
+					t.Fatalf("call %d: %v", call, err)
+				}
+				if token != tt.wantToken {
+					t.Errorf("call %d: token = %q, want %q", call, token, tt.wantToken)
+				}
+				if tt.forget {
+					tokens.forget(settings)
+				}
+			}
+
+			grants := endpoint.grants()
+			if tt.wantRefresh > 0 && len(grants) != tt.wantRefresh {
+				t.Errorf("refreshed %d times, want %d", len(grants), tt.wantRefresh)
+			}
+			for _, request := range endpoint.requests {
+				if got := request.Get("refresh_token"); got != settings.RefreshToken {
+					t.Errorf("refresh_token = %q, want the stored one", got)
+				}
+			}
+		})
+	}
+}
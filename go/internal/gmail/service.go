@@ -3,6 +3,7 @@
 import (
 	"context"
 	"encoding/json"
+	"log"
 	"net/url"
 	"time"
 
@@ -11,12 +12,22 @@
 )
 
 // Service implements inbox.Service for Gmail via IMAP.
-type Service struct{}
+// Use NewService, which sets up its access token cache.
+type Service struct {
+	tokens *tokenCache
+}
+
+func NewService() Service {
+	return Service{tokens: newTokenCache()}
+}
 
 // Compile-time check that Service implements the interfaces.
 var (
-	_ inbox.Service[*Settings, uint]       = Service{}
-	_ inbox.SendToPluginHandler[*Settings] = Service{}
+	_ inbox.Service[*Settings, Result]        = Service{}
+	_ inbox.SendToPluginHandler[*Settings]    = Service{}
+	_ inbox.GestureHandler[*Settings, Result] = Service{}
+	_ inbox.MinRefreshIntervalProvider        = Service{}
+	_ inbox.Pusher[*Settings, Result]         = Service{}
 )
 
 func (s Service) ActionUUID() string {
@@ -27,6 +38,10 @@ func (s Service) RefreshInterval() time.Duration {
 	return RefreshInterval
 }
 
+func (s Service) MinRefreshInterval() time.Duration {
+	return MinRefreshInterval
+}
+
 func (s Service) LogPrefix() string {
 	return "[gmail]"
 }
@@ -40,24 +55,40 @@ func (s Service) ParseSettings(raw json.RawMessage) (*Settings, error) {
 	return &settings, nil
 }
 
-func (s Service) FetchResult(ctx context.Context, settings *Settings) (uint, error) {
-	return FetchUnseenCount(*settings)
+func (s Service) FetchResult(ctx context.Context, settings *Settings) (Result, error) {
+	return FetchUnseenCount(ctx, s.tokens, *settings)
+}
+
+// Watch pushes the unseen count over a long-lived IDLE connection,
+// so the key updates as soon as mail arrives.
+func (s Service) Watch(ctx context.Context, settings *Settings, push func(Result, error)) error {
+	return WatchUnseenCount(ctx, s.tokens, *settings, push)
 }
 
 func (s Service) Render(
 	ctx context.Context,
 	client *streamdeck.Client,
-	result uint,
+	result Result,
 	err error,
 ) error {
-	return inbox.RenderCount(ctx, client, result, err)
+	return inbox.RenderCount(ctx, client, result.Total(), err)
+}
+
+// OpenURL opens the label with the most unseen mail,
+// or the first monitored label if none has any.
+func (s Service) OpenURL(settings *Settings, result Result) string {
+	label := result.Busiest()
+	if label == "" {
+		label = settings.monitoredLabels()[0]
+	}
+
+	return labelURL(settings.Username, label)
 }
 
-func (s Service) OpenURL(settings *Settings, result uint) string {
-	base := "https://mail.google.com/mail/u/0/?authuser=" + settings.Username
+func labelURL(username, label string) string {
+	base := "https://mail.google.com/mail/u/0/?authuser=" + username
 
-	label := settings.Label
-	if label == "" || label == DefaultMailbox {
+	if label == DefaultMailbox {
 		return base + "#inbox"
 	}
 
@@ -80,6 +111,30 @@ func (s Service) OpenURL(settings *Settings, result uint) string {
 	return base + "#label/" + url.PathEscape(label)
 }
 
+// HandleGesture refreshes on a long press. A double press marks the labels as read
+// if the key's DoublePress setting asks for it, and otherwise opens it.
+func (s Service) HandleGesture(gesture inbox.Gesture, settings *Settings, result Result) inbox.KeyAction {
+	switch gesture {
+	case inbox.GestureLongPress:
+		return inbox.KeyAction{Kind: inbox.KeyActionRefresh}
+	case inbox.GestureDoublePress:
+		if settings.DoublePress != DoublePressMarkRead {
+			return inbox.KeyAction{Kind: inbox.KeyActionOpenURL}
+		}
+
+		return inbox.KeyAction{
+			Kind: inbox.KeyActionRun,
+			Run: func(ctx context.Context) error {
+				return MarkAllRead(ctx, s.tokens, *settings)
+			},
+		}
+	case inbox.GesturePress:
+		return inbox.KeyAction{Kind: inbox.KeyActionOpenURL}
+	default:
+		return inbox.KeyAction{Kind: inbox.KeyActionOpenURL}
+	}
+}
+
 // HandleSendToPlugin processes messages from the property inspector.
 func (s Service) HandleSendToPlugin(
 	ctx context.Context,
@@ -96,7 +151,7 @@ func (s Service) HandleSendToPlugin(
 
 	switch request.Action {
 	case "fetchLabels":
-		labels, err := FetchLabels(*settings)
+		labels, err := FetchLabels(ctx, s.tokens, *settings)
 		if err != nil {
 			// Return error as payload to PI, not as Go error
 			//nolint:nilerr // intentionally returning nil error with error payload
@@ -110,8 +165,47 @@ func (s Service) HandleSendToPlugin(
 			"action": "fetchLabels",
 			"labels": labels,
 		}, nil
+	case "authorize":
+		// The user may take minutes to sign in, and the event loop must not
+		// wait for them, so the outcome is sent to the PI separately.
+		go s.authorize(ctx, client, *settings)
+
+		return map[string]interface{}{
+			"action": "authorize",
+			"status": "waiting",
+		}, nil
 	default:
 		//nolint:nilnil // unknown actions are intentionally ignored
 		return nil, nil
 	}
 }
+
+// authorize runs the OAuth2 sign-in and sends the refresh token,
+// or the error, to the property inspector, which saves it in the settings.
+// It outlives the SendToPlugin event, so it has a deadline of its own.
+func (s Service) authorize(ctx context.Context, client *streamdeck.Client, settings Settings) {
+	ctx = context.WithoutCancel(ctx)
+	authCtx, cancel := context.WithTimeout(ctx, AuthorizeTimeout)
+	defer cancel()
+
+	token, err := Authorize(authCtx, settings, func(authURL string) error {
+		parsedURL, err := url.Parse(authURL)
+		if err != nil {
+			return err
+		}
+
+		return client.OpenURL(ctx, *parsedURL)
+	})
+
+	response := map[string]interface{}{"action": "authorize"}
+	if err != nil {
+		log.Println("[gmail]", "authorization failed", err)
+		response["error"] = err.Error()
+	} else {
+		response["refreshToken"] = token.RefreshToken
+	}
+
+	if err := client.SendToPropertyInspector(ctx, response); err != nil {
+		log.Println("[gmail]", "unable to send authorization to the property inspector", err)
+	}
+}
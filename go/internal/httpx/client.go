@@ -0,0 +1,218 @@
+// Package httpx is the HTTP client shared by the REST-based services.
+// It sets timeouts, retries idempotent requests with backoff,
+// and turns non-2xx responses into a StatusError.
+package httpx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultTimeout bounds a single attempt, even if the context has no deadline.
+	DefaultTimeout = 30 * time.Second
+
+	// DefaultMaxRetries is how many times a failed GET is retried.
+	DefaultMaxRetries = 2
+
+	// DefaultBackoff is the wait before the first retry. It doubles on each retry.
+	DefaultBackoff = 500 * time.Millisecond
+
+	// maxRetryWait is the longest we will sleep inside a single fetch.
+	// Longer Retry-After values are left to the caller's poll schedule.
+	maxRetryWait = 10 * time.Second
+)
+
+// Client performs HTTP requests on behalf of one service.
+type Client struct {
+	http       *http.Client
+	maxRetries int
+	backoff    time.Duration
+	logPrefix  string
+}
+
+// New returns a Client with the default timeout and retry policy.
+// The log prefix is the service's prefix, e.g., "[ynab]".
+func New(logPrefix string) *Client {
+	return &Client{
+		http:       &http.Client{Timeout: DefaultTimeout},
+		maxRetries: DefaultMaxRetries,
+		backoff:    DefaultBackoff,
+		logPrefix:  logPrefix,
+	}
+}
+
+// Response is a fully-read HTTP response with a 2xx status code.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Get performs a GET request, retrying transient failures.
+func (c *Client) Get(ctx context.Context, url string, header http.Header) (*Response, error) {
+	return c.Do(ctx, http.MethodGet, url, header, nil)
+}
+
+// Post performs a POST request. POSTs are never retried.
+func (c *Client) Post(
+	ctx context.Context,
+	url string,
+	header http.Header,
+	body []byte,
+) (*Response, error) {
+	return c.Do(ctx, http.MethodPost, url, header, body)
+}
+
+// Do performs a request and reads the whole response body.
+// Idempotent methods are retried with exponential backoff on transport
+// errors, 429s and 5xx responses. Invalid requests and unreadable
+// responses are returned straight away. Any non-2xx response that is not
+// retried is returned as a *StatusError.
+func (c *Client) Do(
+	ctx context.Context,
+	method, url string,
+	header http.Header,
+	body []byte,
+) (*Response, error) {
+	wait := c.backoff
+	for attempt := 0; ; attempt++ {
+		res, err := c.do(ctx, method, url, header, body)
+		if err == nil {
+			return res, nil
+		}
+
+		if attempt >= c.maxRetries || !isIdempotent(method) || !isRetryable(ctx, err) {
+			return nil, err
+		}
+
+		if retryAfter := retryAfterOf(err); retryAfter > wait {
+			wait = retryAfter
+		}
+		if wait > maxRetryWait {
+			return nil, err
+		}
+
+		log.Printf("%s retrying %s %s in %v: %v", c.logPrefix, method, url, wait, err)
+
+		if err := sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+		wait *= 2
+	}
+}
+
+func (c *Client) do(
+	ctx context.Context,
+	method, url string,
+	header http.Header,
+	body []byte,
+) (*Response, error) {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bodyReader)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	for key, values := range header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, &transportError{err: err}
+	}
+
+	defer func(body io.ReadCloser) {
+		err := body.Close()
+		if err != nil {
+			log.Println(c.logPrefix, "error while closing body:", err)
+		}
+	}(res.Body)
+
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading body: %w", err)
+	}
+
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		return nil, newStatusError(res, resBody)
+	}
+
+	return &Response{
+		StatusCode: res.StatusCode,
+		Header:     res.Header,
+		Body:       resBody,
+	}, nil
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+func isRetryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return errors.Is(err, ErrRateLimited) || errors.Is(err, ErrServerError)
+	}
+
+	// A bad URL or an unreadable body will fail the same way next time
+	var transportErr *transportError
+
+	return errors.As(err, &transportErr)
+}
+
+// transportError is a request that failed before it got a response:
+// DNS, a refused connection, a timeout… These are worth retrying.
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string {
+	return "error while doing request: " + e.err.Error()
+}
+
+func (e *transportError) Unwrap() error {
+	return e.err
+}
+
+func retryAfterOf(err error) time.Duration {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.RetryAfter()
+	}
+
+	return 0
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
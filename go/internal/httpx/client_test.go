@@ -0,0 +1,236 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient is a Client that backs off for a millisecond,
+// so retries don't slow the tests down.
+func newTestClient() *Client {
+	c := New("[test]")
+	c.backoff = time.Millisecond
+
+	return c
+}
+
+// reply is how the fake server answers one request.
+type reply struct {
+	status     int
+	retryAfter string
+	// retryAfterIn sends Retry-After as the date this far from now.
+	retryAfterIn time.Duration
+	// hangUp closes the connection without answering.
+	hangUp bool
+}
+
+// fakeServer answers each request with the next reply, repeating the
+// last one once they run out, and counts the requests.
+func fakeServer(t *testing.T, replies ...reply) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(requests.Add(1))
+		reply := replies[min(n, len(replies))-1]
+
+		if reply.hangUp {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+
+				return
+			}
+			_ = conn.Close()
+
+			return
+		}
+		if reply.retryAfter != "" {
+			w.Header().Set("Retry-After", reply.retryAfter)
+		}
+		if reply.retryAfterIn != 0 {
+			w.Header().Set("Retry-After", time.Now().Add(reply.retryAfterIn).UTC().Format(http.TimeFormat))
+		}
+		w.WriteHeader(reply.status)
+		_, _ = w.Write([]byte("body"))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &requests
+}
+
+func TestDo(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		replies []reply
+		// wantErr is the sentinel the error should match, if any.
+		wantErr      error
+		wantRequests int64
+		// wantWait is the least time the call should take.
+		wantWait time.Duration
+		// wantRetryAfter is the Retry-After of the returned StatusError.
+		wantRetryAfter time.Duration
+	}{
+		{
+			name:         "success",
+			replies:      []reply{{status: http.StatusOK}},
+			wantRequests: 1,
+		},
+		{
+			name:         "a 5xx is retried until it succeeds",
+			replies:      []reply{{status: http.StatusBadGateway}, {status: http.StatusOK}},
+			wantRequests: 2,
+		},
+		{
+			name:         "a 5xx is retried at most DefaultMaxRetries times",
+			replies:      []reply{{status: http.StatusServiceUnavailable}},
+			wantErr:      ErrServerError,
+			wantRequests: DefaultMaxRetries + 1,
+		},
+		{
+			name:         "a dropped connection is retried",
+			replies:      []reply{{hangUp: true}, {status: http.StatusOK}},
+			wantRequests: 2,
+		},
+		{
+			name:         "a 429 waits for Retry-After in seconds",
+			replies:      []reply{{status: http.StatusTooManyRequests, retryAfter: "1"}, {status: http.StatusOK}},
+			wantRequests: 2,
+			wantWait:     time.Second,
+		},
+		{
+			name: "a 429 waits for a Retry-After date",
+			replies: []reply{
+				{status: http.StatusTooManyRequests, retryAfterIn: 2 * time.Second},
+				{status: http.StatusOK},
+			},
+			wantRequests: 2,
+			// The date is only precise to the second
+			wantWait: time.Second,
+		},
+		{
+			name:           "a Retry-After beyond maxRetryWait is left to the caller",
+			replies:        []reply{{status: http.StatusTooManyRequests, retryAfter: "60"}},
+			wantErr:        ErrRateLimited,
+			wantRequests:   1,
+			wantRetryAfter: time.Minute,
+		},
+		{
+			name:         "a 4xx is not retried",
+			replies:      []reply{{status: http.StatusNotFound}},
+			wantErr:      ErrNotFound,
+			wantRequests: 1,
+		},
+		{
+			name:         "a 401 is not retried",
+			replies:      []reply{{status: http.StatusUnauthorized}},
+			wantErr:      ErrUnauthorized,
+			wantRequests: 1,
+		},
+		{
+			name:         "a POST is not retried",
+			method:       http.MethodPost,
+			replies:      []reply{{status: http.StatusServiceUnavailable}, {status: http.StatusOK}},
+			wantErr:      ErrServerError,
+			wantRequests: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := fakeServer(t, tt.replies...)
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+
+			start := time.Now()
+			res, err := newTestClient().Do(t.Context(), method, server.URL, nil, nil)
+			elapsed := time.Since(start)
+
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("error = %v", err)
+				}
+				if string(res.Body) != "body" {
+					t.Errorf("Body = %q, want body", res.Body)
+				}
+			} else if !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if got := requests.Load(); got != tt.wantRequests {
+				t.Errorf("made %d requests, want %d", got, tt.wantRequests)
+			}
+			if elapsed < tt.wantWait {
+				t.Errorf("took %v, want at least %v", elapsed, tt.wantWait)
+			}
+
+			var statusErr *StatusError
+			if errors.As(err, &statusErr) && statusErr.RetryAfter() != tt.wantRetryAfter {
+				t.Errorf("RetryAfter() = %v, want %v", statusErr.RetryAfter(), tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+func TestDoInvalidRequest(t *testing.T) {
+	_, err := newTestClient().Get(t.Context(), "http://bad host/", nil)
+	if !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("error = %v, want ErrInvalidRequest", err)
+	}
+	if isRetryable(t.Context(), err) {
+		t.Error("an invalid request would be retried")
+	}
+}
+
+func TestDoCancelledWhileWaiting(t *testing.T) {
+	server, requests := fakeServer(t, reply{status: http.StatusServiceUnavailable})
+	client := newTestClient()
+	client.backoff = 5 * time.Second
+
+	ctx, cancel := context.WithCancel(t.Context())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := client.Get(ctx, server.URL, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed >= client.backoff {
+		t.Errorf("took %v, want to stop waiting when cancelled", elapsed)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("made %d requests, want 1", got)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{value: "", want: 0},
+		{value: "120", want: 2 * time.Minute},
+		{value: "0", want: 0},
+		{value: "-5", want: 0},
+		{value: "soon", want: 0},
+		{value: "Sun, 01 Mar 2026 12:00:30 GMT", want: 30 * time.Second},
+		{value: "Sun, 01 Mar 2026 11:59:00 GMT", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := parseRetryAfter(tt.value, now); got != tt.want {
+				t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
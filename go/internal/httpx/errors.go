@@ -0,0 +1,105 @@
+package httpx
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sentinel errors for the response classes callers usually care about.
+// Use errors.Is to check a *StatusError against them.
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrServerError  = errors.New("server error")
+)
+
+// ErrInvalidRequest is returned when a request cannot even be built,
+// usually because a URL in the settings is malformed.
+var ErrInvalidRequest = errors.New("invalid request")
+
+// maxErrorBody is how much of a failed response body is kept for the error message.
+const maxErrorBody = 256
+
+// StatusError is returned for every response with a non-2xx status code.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Body       string
+
+	retryAfter time.Duration
+}
+
+func newStatusError(res *http.Response, body []byte) *StatusError {
+	text := strings.TrimSpace(string(body))
+	if len(text) > maxErrorBody {
+		text = text[:maxErrorBody] + "…"
+	}
+
+	return &StatusError{
+		StatusCode: res.StatusCode,
+		Status:     res.Status,
+		Body:       text,
+		retryAfter: parseRetryAfter(res.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return "unexpected status " + e.Status
+	}
+
+	return fmt.Sprintf("unexpected status %s: %s", e.Status, e.Body)
+}
+
+// RetryAfter is the parsed Retry-After header, or zero if there was none.
+func (e *StatusError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// Unwrap maps the status code to one of the sentinel errors, if any applies.
+func (e *StatusError) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case e.StatusCode == http.StatusForbidden:
+		return ErrForbidden
+	case e.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case e.StatusCode >= http.StatusInternalServerError:
+		return ErrServerError
+	default:
+		return nil
+	}
+}
+
+// parseRetryAfter accepts both forms allowed by RFC 9110:
+// a number of seconds, or an HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := date.Sub(now); wait > 0 {
+			return wait
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,137 @@
+package httpx
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrStreamIdle is returned by Stream when the server sends nothing,
+// not even a keep-alive, for longer than the idle timeout.
+var ErrStreamIdle = errors.New("event stream went quiet")
+
+// maxEventLine is the longest line accepted in an event stream.
+const maxEventLine = 1 << 20
+
+// Event is one Server-Sent Event.
+type Event struct {
+	ID   string
+	Type string // "message" unless the server named it
+	Data string
+}
+
+// Stream opens a Server-Sent Events stream with a GET request and calls
+// onEvent for each event, until ctx is cancelled, the server ends the
+// stream, or onEvent returns an error, which Stream then returns.
+// The stream has no overall timeout, but it fails with ErrStreamIdle if
+// nothing arrives for idleTimeout. Streams are never retried.
+func (c *Client) Stream(
+	ctx context.Context,
+	url string,
+	header http.Header,
+	idleTimeout time.Duration,
+	onEvent func(Event) error,
+) error {
+	streamCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	req, err := http.NewRequestWithContext(streamCtx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	for key, values := range header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+
+	watchdog := time.AfterFunc(idleTimeout, func() { cancel(ErrStreamIdle) })
+	defer watchdog.Stop()
+
+	// The client's timeout covers the whole response, which never ends
+	streamClient := &http.Client{Transport: c.http.Transport}
+	res, err := streamClient.Do(req)
+	if err != nil {
+		return streamError(streamCtx, fmt.Errorf("error while opening stream: %w", err))
+	}
+
+	defer func(body io.ReadCloser) {
+		err := body.Close()
+		if err != nil {
+			log.Println(c.logPrefix, "error while closing stream:", err)
+		}
+	}(res.Body)
+
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorBody))
+
+		return newStatusError(res, body)
+	}
+
+	scanner := bufio.NewScanner(res.Body)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxEventLine)
+
+	var event Event
+	var data strings.Builder
+	for scanner.Scan() {
+		watchdog.Reset(idleTimeout)
+
+		line := scanner.Text()
+		if line == "" {
+			// A blank line dispatches the event, if it has any data
+			if data.Len() > 0 {
+				event.Data = strings.TrimSuffix(data.String(), "\n")
+				if event.Type == "" {
+					event.Type = "message"
+				}
+				if err := onEvent(event); err != nil {
+					return err
+				}
+			}
+			event = Event{ID: event.ID}
+			data.Reset()
+
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "":
+			// A comment, often sent as a keep-alive
+		case "event":
+			event.Type = value
+		case "data":
+			data.WriteString(value)
+			data.WriteString("\n")
+		case "id":
+			event.ID = value
+		default:
+			// "retry" and unknown fields are ignored
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return streamError(streamCtx, fmt.Errorf("error while reading stream: %w", err))
+	}
+
+	return streamError(streamCtx, io.ErrUnexpectedEOF)
+}
+
+// streamError reports why the stream stopped: the idle watchdog,
+// the caller's cancellation, or err.
+func streamError(streamCtx context.Context, err error) error {
+	if cause := context.Cause(streamCtx); cause != nil {
+		return cause
+	}
+
+	return err
+}
@@ -0,0 +1,57 @@
+package httpx
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestStream(t *testing.T) {
+	// The server sends two events and a keep-alive, then goes quiet
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "text/event-stream" {
+			t.Errorf("Accept = %q", r.Header.Get("Accept"))
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = fmt.Fprint(w, "id: 1\nevent: state\ndata: {\"a\":\ndata: 1}\n\n")
+		_, _ = fmt.Fprint(w, ": ping\n\n")
+		_, _ = fmt.Fprint(w, "data: plain\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+
+	var events []Event
+	err := newTestClient().Stream(t.Context(), server.URL, nil, 50*time.Millisecond, func(event Event) error {
+		events = append(events, event)
+
+		return nil
+	})
+
+	if !errors.Is(err, ErrStreamIdle) {
+		t.Errorf("error = %v, want ErrStreamIdle", err)
+	}
+	want := []Event{
+		{ID: "1", Type: "state", Data: "{\"a\":\n1}"},
+		{ID: "1", Type: "message", Data: "plain"},
+	}
+	if !slices.Equal(events, want) {
+		t.Errorf("events = %q, want %q", events, want)
+	}
+}
+
+func TestStreamStatusError(t *testing.T) {
+	server, requests := fakeServer(t, reply{status: http.StatusServiceUnavailable})
+
+	err := newTestClient().Stream(t.Context(), server.URL, nil, time.Second, func(Event) error { return nil })
+	if !errors.Is(err, ErrServerError) {
+		t.Errorf("error = %v, want ErrServerError", err)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("made %d requests, want 1: streams are never retried", got)
+	}
+}
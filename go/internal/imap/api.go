@@ -0,0 +1,129 @@
+package imap
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"ca.michaelabon.inboxes/internal/imapx"
+	"ca.michaelabon.inboxes/internal/inbox"
+	"github.com/emersion/go-imap/client"
+)
+
+type Settings struct {
+	Host       string
+	Port       string        // Defaults to 993, or 143 for STARTTLS and plain connections
+	TLSMode    imapx.TLSMode // Defaults to implicit TLS
+	Username   string
+	Password   string
+	Mailbox    string // Mailbox to monitor (default: "INBOX")
+	WebmailURL string // Opened when the key is pressed
+
+	// DoublePress is DoublePressMarkRead to mark the mailbox as read on a double press.
+	// By default, a double press opens WebmailURL like a short press.
+	DoublePress string
+}
+
+// DoublePressMarkRead marks the monitored mailbox as read on a double press.
+// It can't be undone, so keys only do it when asked to.
+const DoublePressMarkRead = "markRead"
+
+const RefreshInterval = time.Minute
+
+// MinRefreshInterval protects the account: every refresh logs in over IMAP.
+const MinRefreshInterval = 30 * time.Second
+
+func (s Settings) server() imapx.Server {
+	return imapx.Server{Host: s.Host, Port: s.Port, TLS: s.TLSMode}
+}
+
+func (s Settings) check() error {
+	if s.Host == "" {
+		return inbox.NewError(inbox.CategoryConfig, "missing Host")
+	}
+	if s.Username == "" {
+		return inbox.NewError(inbox.CategoryConfig, "missing Username")
+	}
+	if s.Password == "" {
+		return inbox.NewError(inbox.CategoryConfig, "missing Password")
+	}
+
+	return nil
+}
+
+// dial connects and logs in. The returned function logs out and must always be called.
+func dial(ctx context.Context, settings Settings) (*client.Client, func(), error) {
+	login := imapx.PasswordLogin(settings.Username, settings.Password)
+
+	return imapx.Dial(ctx, settings.server(), login, "[imap]")
+}
+
+func FetchUnseenCount(ctx context.Context, settings Settings) (uint, error) {
+	if err := settings.check(); err != nil {
+		return 0, err
+	}
+
+	c, closeFn, err := dial(ctx, settings)
+	if err != nil {
+		return 0, err
+	}
+	defer closeFn()
+
+	return imapx.UnseenCount(c, imapx.MailboxOrDefault(settings.Mailbox))
+}
+
+// WatchUnseenCount keeps one connection open on the monitored mailbox
+// and pushes its unseen count whenever it changes. See imapx.Watch.
+func WatchUnseenCount(ctx context.Context, settings Settings, push func(uint, error)) error {
+	if err := settings.check(); err != nil {
+		return err
+	}
+
+	c, closeFn, err := dial(ctx, settings)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	return imapx.Watch(ctx, c, imapx.MailboxOrDefault(settings.Mailbox), func(count uint) {
+		push(count, nil)
+	})
+}
+
+// MarkAllRead flags every unseen message in the monitored mailbox as seen.
+func MarkAllRead(ctx context.Context, settings Settings) error {
+	if err := settings.check(); err != nil {
+		return err
+	}
+
+	c, closeFn, err := dial(ctx, settings)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	mailbox := imapx.MailboxOrDefault(settings.Mailbox)
+	marked, err := imapx.MarkAllRead(c, mailbox)
+	if err != nil {
+		return err
+	}
+
+	log.Println("[imap]", "marked", marked, "messages as read in", mailbox)
+
+	return nil
+}
+
+// FetchMailboxes returns the names of all selectable mailboxes.
+func FetchMailboxes(ctx context.Context, settings Settings) ([]string, error) {
+	if err := settings.check(); err != nil {
+		return nil, err
+	}
+
+	c, closeFn, err := dial(ctx, settings)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFn()
+
+	return imapx.ListMailboxes(c)
+}
@@ -0,0 +1,130 @@
+package imap
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"ca.michaelabon.inboxes/internal/inbox"
+	"github.com/samwho/streamdeck"
+)
+
+// Service implements inbox.Service for any IMAP server.
+type Service struct{}
+
+// Compile-time check that Service implements the interfaces.
+var (
+	_ inbox.Service[*Settings, uint]        = Service{}
+	_ inbox.SendToPluginHandler[*Settings]  = Service{}
+	_ inbox.GestureHandler[*Settings, uint] = Service{}
+	_ inbox.MinRefreshIntervalProvider      = Service{}
+	_ inbox.Pusher[*Settings, uint]         = Service{}
+)
+
+func (s Service) ActionUUID() string {
+	return "ca.michaelabon.streamdeck-inboxes.imap.action"
+}
+
+func (s Service) RefreshInterval() time.Duration {
+	return RefreshInterval
+}
+
+func (s Service) MinRefreshInterval() time.Duration {
+	return MinRefreshInterval
+}
+
+func (s Service) LogPrefix() string {
+	return "[imap]"
+}
+
+func (s Service) ParseSettings(raw json.RawMessage) (*Settings, error) {
+	var settings Settings
+	if err := json.Unmarshal(raw, &settings); err != nil {
+		return nil, err
+	}
+
+	return &settings, nil
+}
+
+func (s Service) FetchResult(ctx context.Context, settings *Settings) (uint, error) {
+	return FetchUnseenCount(ctx, *settings)
+}
+
+// Watch pushes the unseen count over a long-lived IDLE connection,
+// falling back to polling if the server has no IDLE.
+func (s Service) Watch(ctx context.Context, settings *Settings, push func(uint, error)) error {
+	return WatchUnseenCount(ctx, *settings, push)
+}
+
+func (s Service) Render(
+	ctx context.Context,
+	client *streamdeck.Client,
+	result uint,
+	err error,
+) error {
+	return inbox.RenderCount(ctx, client, result, err)
+}
+
+func (s Service) OpenURL(settings *Settings, result uint) string {
+	return settings.WebmailURL
+}
+
+// HandleGesture refreshes on a long press. A double press marks the mailbox as read
+// if the key's DoublePress setting asks for it, and otherwise opens it.
+func (s Service) HandleGesture(gesture inbox.Gesture, settings *Settings, result uint) inbox.KeyAction {
+	switch gesture {
+	case inbox.GestureLongPress:
+		return inbox.KeyAction{Kind: inbox.KeyActionRefresh}
+	case inbox.GestureDoublePress:
+		if settings.DoublePress != DoublePressMarkRead {
+			return inbox.KeyAction{Kind: inbox.KeyActionOpenURL}
+		}
+
+		return inbox.KeyAction{
+			Kind: inbox.KeyActionRun,
+			Run: func(ctx context.Context) error {
+				return MarkAllRead(ctx, *settings)
+			},
+		}
+	case inbox.GesturePress:
+		return inbox.KeyAction{Kind: inbox.KeyActionOpenURL}
+	default:
+		return inbox.KeyAction{Kind: inbox.KeyActionOpenURL}
+	}
+}
+
+// HandleSendToPlugin processes messages from the property inspector.
+func (s Service) HandleSendToPlugin(
+	ctx context.Context,
+	client *streamdeck.Client,
+	payload json.RawMessage,
+	settings *Settings,
+) (interface{}, error) {
+	var request struct {
+		Action string `json:"action"`
+	}
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return nil, err
+	}
+
+	switch request.Action {
+	case "fetchMailboxes":
+		mailboxes, err := FetchMailboxes(ctx, *settings)
+		if err != nil {
+			// Return error as payload to PI, not as Go error
+			//nolint:nilerr // intentionally returning nil error with error payload
+			return map[string]interface{}{
+				"action": "fetchMailboxes",
+				"error":  err.Error(),
+			}, nil
+		}
+
+		return map[string]interface{}{
+			"action":    "fetchMailboxes",
+			"mailboxes": mailboxes,
+		}, nil
+	default:
+		//nolint:nilnil // unknown actions are intentionally ignored
+		return nil, nil
+	}
+}
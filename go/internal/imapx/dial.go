@@ -0,0 +1,167 @@
+// Package imapx holds the IMAP plumbing shared by the mail services:
+// dialing with a context, counting unseen mail, listing mailboxes,
+// and watching a mailbox with IDLE.
+package imapx
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"ca.michaelabon.inboxes/internal/inbox"
+	"github.com/emersion/go-imap/client"
+)
+
+// CommandTimeout bounds dialing and each IMAP command,
+// even if the caller's context has no deadline.
+const CommandTimeout = 30 * time.Second
+
+// TLSMode is how the connection to the server is secured.
+type TLSMode string
+
+const (
+	// TLSImplicit connects with TLS from the start, usually on port 993. This is the default.
+	TLSImplicit TLSMode = "tls"
+	// TLSStartTLS connects in plain text and upgrades with STARTTLS, usually on port 143.
+	TLSStartTLS TLSMode = "starttls"
+	// TLSNone never encrypts the connection. Only use it for local servers.
+	TLSNone TLSMode = "none"
+)
+
+// DefaultPort returns the usual port for the TLS mode.
+func (m TLSMode) DefaultPort() string {
+	if m == TLSStartTLS || m == TLSNone {
+		return "143"
+	}
+
+	return "993"
+}
+
+// Server says where and how to connect.
+type Server struct {
+	Host string
+	Port string // Defaults to the TLS mode's DefaultPort
+	TLS  TLSMode
+	// RootCAs are trusted instead of the system's, e.g., for a self-signed server.
+	RootCAs *x509.CertPool
+}
+
+func (s Server) addr() string {
+	port := s.Port
+	if port == "" {
+		port = s.TLS.DefaultPort()
+	}
+
+	return net.JoinHostPort(s.Host, port)
+}
+
+// LoginFunc authenticates a freshly dialed connection.
+// It should return an inbox.CategoryAuth error if the server rejects it.
+type LoginFunc func(c *client.Client) error
+
+// PasswordLogin returns a LoginFunc for plain LOGIN.
+func PasswordLogin(username, password string) LoginFunc {
+	return func(c *client.Client) error {
+		if err := c.Login(username, password); err != nil {
+			return inbox.WrapError(inbox.CategoryAuth, fmt.Errorf("error during login: %w", err))
+		}
+
+		return nil
+	}
+}
+
+// dialerFunc adapts a function to the go-imap Dialer interface.
+type dialerFunc func(network, address string) (net.Conn, error)
+
+func (f dialerFunc) Dial(network, address string) (net.Conn, error) {
+	return f(network, address)
+}
+
+// Dial connects to the server and logs in.
+// The connection is torn down if ctx is cancelled, which unblocks any
+// in-flight command. The returned function logs out and must always be called.
+func Dial(ctx context.Context, server Server, login LoginFunc, logPrefix string) (*client.Client, func(), error) {
+	if server.Host == "" {
+		return nil, nil, inbox.NewError(inbox.CategoryConfig, "missing Host")
+	}
+
+	netDialer := &net.Dialer{Timeout: CommandTimeout}
+	dialer := dialerFunc(func(network, address string) (net.Conn, error) {
+		return netDialer.DialContext(ctx, network, address)
+	})
+	tlsConfig := &tls.Config{
+		ServerName: server.Host,
+		RootCAs:    server.RootCAs,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	var c *client.Client
+	var err error
+	switch server.TLS {
+	case TLSStartTLS, TLSNone:
+		c, err = client.DialWithDialer(dialer, server.addr())
+	case TLSImplicit:
+		c, err = client.DialWithDialerTLS(dialer, server.addr(), tlsConfig)
+	default:
+		c, err = client.DialWithDialerTLS(dialer, server.addr(), tlsConfig)
+	}
+	if err != nil {
+		return nil, nil, inbox.WrapError(
+			inbox.CategoryNetwork,
+			fmt.Errorf("error while dialing the server: %w", err),
+		)
+	}
+	c.Timeout = CommandTimeout
+
+	stop := context.AfterFunc(ctx, func() {
+		if err := c.Terminate(); err != nil {
+			log.Println(logPrefix, "unable to terminate imapClient", err)
+		}
+	})
+
+	// Don't forget to logout
+	closeFn := func() {
+		if !stop() {
+			// Already terminated because the context was cancelled
+			return
+		}
+		if err := c.Logout(); err != nil {
+			log.Println(logPrefix, "unable to close imapClient", err)
+		}
+	}
+
+	if server.TLS == TLSStartTLS {
+		if ok, err := c.SupportStartTLS(); err != nil {
+			closeFn()
+
+			return nil, nil, inbox.WrapError(
+				inbox.CategoryNetwork,
+				fmt.Errorf("unable to check for STARTTLS support: %w", err),
+			)
+		} else if !ok {
+			closeFn()
+
+			return nil, nil, inbox.NewError(inbox.CategoryConfig, "the server does not support STARTTLS")
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			closeFn()
+
+			return nil, nil, inbox.WrapError(
+				inbox.CategoryNetwork,
+				fmt.Errorf("error during STARTTLS: %w", err),
+			)
+		}
+	}
+
+	if err := login(c); err != nil {
+		closeFn()
+
+		return nil, nil, err
+	}
+
+	return c, closeFn, nil
+}
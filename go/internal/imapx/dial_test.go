@@ -0,0 +1,276 @@
+package imapx
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"ca.michaelabon.inboxes/internal/inbox"
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/server"
+)
+
+// The memory backend's only user.
+const (
+	testUsername = "username"
+	testPassword = "password"
+)
+
+// selfSignedCert returns a certificate for 127.0.0.1 and a pool that trusts it.
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+// startMemoryServer serves go-imap's memory backend on 127.0.0.1 with the
+// TLS mode, and returns where to find it. Its INBOX holds one seen message
+// and two unseen ones, and there is an empty Archive mailbox.
+func startMemoryServer(t *testing.T, mode TLSMode) Server {
+	t.Helper()
+
+	be := memory.New()
+	user, err := be.Login(nil, testUsername, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inboxMailbox, err := user.GetMailbox(DefaultMailbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		body := bytes.NewBufferString("Subject: Unseen\r\n\r\nHello")
+		if err := inboxMailbox.CreateMessage(nil, time.Now(), body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := user.CreateMailbox("Archive"); err != nil {
+		t.Fatal(err)
+	}
+
+	cert, pool := selfSignedCert(t)
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := server.New(unseenBackend{be})
+	s.ErrorLog = discardLogger{}
+	switch mode {
+	case TLSImplicit:
+		listener = tls.NewListener(listener, tlsConfig)
+	case TLSStartTLS:
+		s.TLSConfig = tlsConfig
+	case TLSNone:
+		s.AllowInsecureAuth = true
+	}
+	go func() { _ = s.Serve(listener) }()
+	t.Cleanup(func() { _ = s.Close() })
+
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return Server{Host: host, Port: port, TLS: mode, RootCAs: pool}
+}
+
+// unseenBackend fills in the STATUS UNSEEN count,
+// which go-imap's memory backend always reports as 0.
+type unseenBackend struct{ *memory.Backend }
+
+func (b unseenBackend) Login(connInfo *imap.ConnInfo, username, password string) (backend.User, error) {
+	user, err := b.Backend.Login(connInfo, username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	return unseenUser{user}, nil
+}
+
+type unseenUser struct{ backend.User }
+
+func (u unseenUser) GetMailbox(name string) (backend.Mailbox, error) {
+	mailbox, err := u.User.GetMailbox(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return unseenMailbox{mailbox}, nil
+}
+
+type unseenMailbox struct{ backend.Mailbox }
+
+func (m unseenMailbox) Status(items []imap.StatusItem) (*imap.MailboxStatus, error) {
+	status, err := m.Mailbox.Status(items)
+	if err != nil || !slices.Contains(items, imap.StatusUnseen) {
+		return status, err
+	}
+
+	criteria := imap.NewSearchCriteria()
+	criteria.WithoutFlags = []string{imap.SeenFlag}
+	unseen, err := m.SearchMessages(false, criteria)
+	if err != nil {
+		return nil, err
+	}
+	status.Unseen = uint32(len(unseen))
+
+	return status, nil
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Printf(format string, v ...interface{}) {}
+func (discardLogger) Println(v ...interface{})               {}
+
+func TestDial(t *testing.T) {
+	for _, mode := range []TLSMode{TLSImplicit, TLSStartTLS, TLSNone} {
+		t.Run(string(mode), func(t *testing.T) {
+			srv := startMemoryServer(t, mode)
+
+			c, closeFn, err := Dial(t.Context(), srv, PasswordLogin(testUsername, testPassword), "[test]")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer closeFn()
+
+			if wantTLS := mode != TLSNone; c.IsTLS() != wantTLS {
+				t.Errorf("IsTLS() = %v, want %v", c.IsTLS(), wantTLS)
+			}
+
+			unseen, err := UnseenCount(c, DefaultMailbox)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if unseen != 2 {
+				t.Errorf("UnseenCount = %d, want 2", unseen)
+			}
+
+			mailboxes, err := ListMailboxes(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{DefaultMailbox, "Archive"}; !slices.Equal(mailboxes, want) {
+				t.Errorf("ListMailboxes = %v, want %v", mailboxes, want)
+			}
+		})
+	}
+}
+
+func TestDialFailures(t *testing.T) {
+	tests := []struct {
+		name         string
+		mode         TLSMode
+		change       func(srv *Server)
+		password     string
+		wantCategory inbox.ErrorCategory
+	}{
+		{
+			name:         "wrong password",
+			mode:         TLSImplicit,
+			password:     "wrong",
+			wantCategory: inbox.CategoryAuth,
+		},
+		{
+			name:         "untrusted certificate",
+			mode:         TLSImplicit,
+			change:       func(srv *Server) { srv.RootCAs = x509.NewCertPool() },
+			password:     testPassword,
+			wantCategory: inbox.CategoryNetwork,
+		},
+		{
+			name:         "STARTTLS on a server without it",
+			mode:         TLSNone,
+			change:       func(srv *Server) { srv.TLS = TLSStartTLS },
+			password:     testPassword,
+			wantCategory: inbox.CategoryConfig,
+		},
+		{
+			name:         "missing host",
+			mode:         TLSNone,
+			change:       func(srv *Server) { srv.Host = "" },
+			password:     testPassword,
+			wantCategory: inbox.CategoryConfig,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := startMemoryServer(t, tt.mode)
+			if tt.change != nil {
+				tt.change(&srv)
+			}
+
+			_, _, err := Dial(t.Context(), srv, PasswordLogin(testUsername, tt.password), "[test]")
+			if got := inbox.Categorize(err); got != tt.wantCategory {
+				t.Errorf("category = %v, want %v (%v)", got, tt.wantCategory, err)
+			}
+		})
+	}
+}
+
+func TestMarkAllRead(t *testing.T) {
+	srv := startMemoryServer(t, TLSNone)
+
+	c, closeFn, err := Dial(t.Context(), srv, PasswordLogin(testUsername, testPassword), "[test]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeFn()
+
+	marked, err := MarkAllRead(c, DefaultMailbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if marked != 2 {
+		t.Errorf("marked %d messages, want 2", marked)
+	}
+
+	unseen, err := UnseenCount(c, DefaultMailbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unseen != 0 {
+		t.Errorf("UnseenCount = %d after marking all as read, want 0", unseen)
+	}
+}
@@ -0,0 +1,119 @@
+package imapx
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"ca.michaelabon.inboxes/internal/inbox"
+	"github.com/emersion/go-imap/client"
+)
+
+// idleRestart is how often IDLE is re-issued on a quiet connection.
+// RFC 2177 lets servers drop a client that idles for more than 29 minutes.
+const idleRestart = 20 * time.Minute
+
+// updatesBuffer is the buffer size for unilateral server updates,
+// which arrive in bursts, e.g., when many messages are marked as read.
+const updatesBuffer = 64
+
+// Watch selects a mailbox on an open connection and pushes its unseen count,
+// then pushes it again whenever the server reports a change, using IDLE
+// (RFC 2177). It returns inbox.ErrPushUnsupported if the server has no IDLE.
+// It returns when ctx is cancelled or the connection fails.
+// The connection should not be used for anything else while it is watched.
+func Watch(ctx context.Context, c *client.Client, mailbox string, push func(uint)) error {
+	if ok, err := c.Support("IDLE"); err != nil {
+		return fmt.Errorf("unable to check for IDLE support: %w", err)
+	} else if !ok {
+		return inbox.ErrPushUnsupported
+	}
+
+	updates := make(chan client.Update, updatesBuffer)
+	changed := make(chan struct{}, 1)
+	c.Updates = updates
+	go watchUpdates(c, updates, changed)
+
+	if _, err := c.Select(mailbox, true); err != nil {
+		return fmt.Errorf("unable to select %s: %w", mailbox, err)
+	}
+
+	count, err := countUnseen(c)
+	if err != nil {
+		return err
+	}
+	push(count)
+
+	for {
+		if err := idleUntilChanged(ctx, c, changed); err != nil {
+			return err
+		}
+
+		latest, err := countUnseen(c)
+		if err != nil {
+			return err
+		}
+		if latest != count {
+			count = latest
+			push(count)
+		}
+	}
+}
+
+// watchUpdates reads every update the server sends until the connection
+// closes, and signals changed, without waiting, for each one.
+// The client blocks until each update is read, whether it is idling or
+// running a command, so nothing else may stop reading them.
+func watchUpdates(c *client.Client, updates <-chan client.Update, changed chan<- struct{}) {
+	for {
+		select {
+		case <-updates:
+			select {
+			case changed <- struct{}{}:
+			default:
+				// A change is already waiting to be counted
+			}
+		case <-c.LoggedOut():
+			return
+		}
+	}
+}
+
+// idleUntilChanged idles until the server reports a change to the selected
+// mailbox, then leaves IDLE so that commands can be sent again.
+func idleUntilChanged(ctx context.Context, c *client.Client, changed chan struct{}) error {
+	// The command timeout would otherwise cut every IDLE short
+	c.Timeout = 0
+	defer func() { c.Timeout = CommandTimeout }()
+
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Idle(stop, &client.IdleOptions{LogoutTimeout: idleRestart})
+	}()
+
+	select {
+	case <-changed:
+		close(stop)
+		if err := <-done; err != nil {
+			return fmt.Errorf("error while leaving IDLE: %w", err)
+		}
+	case err := <-done:
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil {
+			return inbox.NewError(inbox.CategoryNetwork, "IDLE ended unexpectedly")
+		}
+
+		return inbox.WrapError(inbox.CategoryNetwork, fmt.Errorf("error while idling: %w", err))
+	}
+
+	// One recount covers every change reported while leaving IDLE
+	select {
+	case <-changed:
+	default:
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package imapx
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/client"
+)
+
+// burst is more updates than the client's update buffer holds.
+const burst = 4 * updatesBuffer
+
+// serveBurstyIMAP plays an IMAP server that floods the client with
+// EXISTS updates while it idles and again while it searches.
+func serveBurstyIMAP(t *testing.T, conn net.Conn) {
+	t.Helper()
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	write := func(format string, args ...interface{}) bool {
+		_, err := fmt.Fprintf(conn, format+"\r\n", args...)
+
+		return err == nil
+	}
+	flood := func() bool {
+		for i := 1; i <= burst; i++ {
+			if !write("* %d EXISTS", i) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	if !write("* PREAUTH [CAPABILITY IMAP4rev1 IDLE] ready") {
+		return
+	}
+	searches := 0
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		tag, command, _ := strings.Cut(strings.TrimSpace(line), " ")
+		verb, _, _ := strings.Cut(command, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EXAMINE", "SELECT":
+			write("* 0 EXISTS")
+			write("* FLAGS (\\Seen)")
+			write("%s OK [READ-ONLY] examined", tag)
+		case "SEARCH":
+			searches++
+			if searches == 1 {
+				write("* SEARCH")
+			} else {
+				flood()
+				write("* SEARCH 1 2 3")
+			}
+			write("%s OK searched", tag)
+		case "IDLE":
+			write("+ idling")
+			flood()
+			if done, err := reader.ReadString('\n'); err != nil || strings.TrimSpace(done) != "DONE" {
+				return
+			}
+			write("%s OK idled", tag)
+		case "LOGOUT":
+			write("* BYE")
+			write("%s OK logged out", tag)
+
+			return
+		default:
+			write("%s BAD unexpected %s", tag, verb)
+		}
+	}
+}
+
+func TestWatchSurvivesUpdateBursts(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	go serveBurstyIMAP(t, serverConn)
+
+	c, err := client.New(clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Terminate() }()
+
+	counts := make(chan uint, 10)
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- Watch(t.Context(), c, DefaultMailbox, func(count uint) {
+			counts <- count
+		})
+	}()
+
+	for _, want := range []uint{0, 3} {
+		select {
+		case got := <-counts:
+			if got != want {
+				t.Fatalf("pushed %d, want %d", got, want)
+			}
+		case err := <-watchErr:
+			t.Fatalf("Watch returned early: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Watch hung before pushing %d", want)
+		}
+	}
+}
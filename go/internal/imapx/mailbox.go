@@ -0,0 +1,119 @@
+package imapx
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// DefaultMailbox is the mailbox to use when none was chosen.
+const DefaultMailbox = "INBOX"
+
+// mailboxChannelBuffer is the buffer size for the mailbox listing channel.
+const mailboxChannelBuffer = 100
+
+// MailboxOrDefault returns the mailbox, or DefaultMailbox if it is empty.
+func MailboxOrDefault(mailbox string) string {
+	if mailbox == "" {
+		return DefaultMailbox
+	}
+
+	return mailbox
+}
+
+// UnseenCount asks for a mailbox's unseen count with STATUS,
+// without selecting it.
+func UnseenCount(c *client.Client, mailbox string) (uint, error) {
+	status, err := c.Status(mailbox, []imap.StatusItem{imap.StatusUnseen})
+	if err != nil {
+		return 0, fmt.Errorf("unable to get status of %s: %w", mailbox, err)
+	}
+
+	return uint(status.Unseen), nil
+}
+
+// countUnseen counts the unseen messages in the selected mailbox.
+func countUnseen(c *client.Client) (uint, error) {
+	criteria := imap.NewSearchCriteria()
+	criteria.WithoutFlags = []string{imap.SeenFlag}
+
+	seqNums, err := c.Search(criteria)
+	if err != nil {
+		return 0, fmt.Errorf("unable to search for unseen mail: %w", err)
+	}
+
+	return uint(len(seqNums)), nil
+}
+
+// MarkAllRead flags every unseen message in a mailbox as seen.
+// It returns how many messages it marked.
+func MarkAllRead(c *client.Client, mailbox string) (int, error) {
+	if _, err := c.Select(mailbox, false); err != nil {
+		return 0, fmt.Errorf("unable to select %s: %w", mailbox, err)
+	}
+
+	criteria := imap.NewSearchCriteria()
+	criteria.WithoutFlags = []string{imap.SeenFlag}
+	uids, err := c.UidSearch(criteria)
+	if err != nil {
+		return 0, fmt.Errorf("unable to search %s for unseen mail: %w", mailbox, err)
+	}
+	if len(uids) == 0 {
+		return 0, nil
+	}
+
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(uids...)
+	flagsOp := imap.FormatFlagsOp(imap.AddFlags, true)
+	if err := c.UidStore(seqSet, flagsOp, []interface{}{imap.SeenFlag}, nil); err != nil {
+		return 0, fmt.Errorf("unable to mark %s as read: %w", mailbox, err)
+	}
+
+	return len(uids), nil
+}
+
+// ListMailboxes returns the names of every mailbox that can be selected,
+// sorted alphabetically, with INBOX first.
+func ListMailboxes(c *client.Client) ([]string, error) {
+	mailboxes := make(chan *imap.MailboxInfo, mailboxChannelBuffer)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.List("", "*", mailboxes)
+	}()
+
+	var names []string
+	for m := range mailboxes {
+		if hasAttribute(m, imap.NoSelectAttr) {
+			continue
+		}
+		names = append(names, m.Name)
+	}
+
+	if err := <-done; err != nil {
+		return nil, fmt.Errorf("error listing mailboxes: %w", err)
+	}
+
+	// Sort alphabetically, but put INBOX first
+	sort.Strings(names)
+	for i, name := range names {
+		if name == DefaultMailbox && i > 0 {
+			names = append([]string{DefaultMailbox}, append(names[:i], names[i+1:]...)...)
+
+			break
+		}
+	}
+
+	return names, nil
+}
+
+func hasAttribute(m *imap.MailboxInfo, attribute string) bool {
+	for _, attr := range m.Attributes {
+		if attr == attribute {
+			return true
+		}
+	}
+
+	return false
+}
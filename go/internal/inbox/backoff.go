@@ -0,0 +1,49 @@
+package inbox
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// MaxBackoff caps how far apart polls get while a button keeps failing.
+	MaxBackoff = 30 * time.Minute
+
+	// maxBackoffShift stops the doubling long before it could overflow.
+	maxBackoffShift = 16
+)
+
+// RetryAfterHint is implemented by errors and results that know how long
+// the upstream API wants us to wait before the next request,
+// e.g., from a Retry-After or rate-limit header.
+type RetryAfterHint interface {
+	RetryAfter() time.Duration
+}
+
+// retryAfter returns the wait requested by a fetch's error or result, if any.
+func retryAfter(result any, err error) time.Duration {
+	var hint RetryAfterHint
+	if errors.As(err, &hint) {
+		return hint.RetryAfter()
+	}
+	if hint, ok := result.(RetryAfterHint); ok {
+		return hint.RetryAfter()
+	}
+
+	return 0
+}
+
+// nextDelay returns how long to wait before the next poll.
+// It is the normal interval after a success, doubling with each consecutive
+// failure up to MaxBackoff, and never sooner than the upstream asked for.
+func nextDelay(interval time.Duration, failures int, retryAfter time.Duration) time.Duration {
+	delay := interval
+	if failures > 0 {
+		delay = interval << min(failures, maxBackoffShift)
+		if delay > MaxBackoff || delay <= 0 {
+			delay = max(interval, MaxBackoff)
+		}
+	}
+
+	return max(delay, retryAfter)
+}
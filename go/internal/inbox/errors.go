@@ -0,0 +1,131 @@
+package inbox
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"ca.michaelabon.inboxes/internal/httpx"
+)
+
+// ErrorCategory classifies why a fetch failed,
+// so the key can tell "fix your token" apart from "the Wi-Fi is down".
+type ErrorCategory int
+
+const (
+	// CategoryUnknown is any error we could not classify.
+	CategoryUnknown ErrorCategory = iota
+	// CategoryConfig means the key's settings are missing or invalid.
+	CategoryConfig
+	// CategoryAuth means the server rejected the credentials.
+	CategoryAuth
+	// CategoryRateLimit means the server asked us to slow down.
+	CategoryRateLimit
+	// CategoryNetwork means the server could not be reached.
+	CategoryNetwork
+	// CategoryUpstream means the server was reached but failed (5xx).
+	CategoryUpstream
+)
+
+func (c ErrorCategory) String() string {
+	switch c {
+	case CategoryConfig:
+		return "config"
+	case CategoryAuth:
+		return "auth"
+	case CategoryRateLimit:
+		return "rate-limit"
+	case CategoryNetwork:
+		return "network"
+	case CategoryUpstream:
+		return "upstream"
+	case CategoryUnknown:
+		return "unknown"
+	default:
+		return "unknown"
+	}
+}
+
+// Glyph is the short title shown on the key for this category.
+func (c ErrorCategory) Glyph() string {
+	switch c {
+	case CategoryConfig:
+		return "cfg"
+	case CategoryAuth:
+		return "auth"
+	case CategoryRateLimit:
+		return "429"
+	case CategoryNetwork:
+		return "net"
+	case CategoryUpstream:
+		return "5xx"
+	case CategoryUnknown:
+		return "!"
+	default:
+		return "!"
+	}
+}
+
+// CategorizedError attaches an ErrorCategory to an error.
+type CategorizedError struct {
+	Category ErrorCategory
+	Err      error
+}
+
+func (e *CategorizedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CategorizedError) Unwrap() error {
+	return e.Err
+}
+
+// NewError returns an error with the given category and message.
+func NewError(category ErrorCategory, message string) error {
+	return &CategorizedError{Category: category, Err: errors.New(message)}
+}
+
+// WrapError attaches a category to err. It returns nil if err is nil.
+func WrapError(category ErrorCategory, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &CategorizedError{Category: category, Err: err}
+}
+
+// Categorize returns the category of err.
+// An explicit CategorizedError anywhere in the chain wins.
+// Otherwise, HTTP status errors and network errors are recognised.
+func Categorize(err error) ErrorCategory {
+	if err == nil {
+		return CategoryUnknown
+	}
+
+	var categorized *CategorizedError
+	if errors.As(err, &categorized) {
+		return categorized.Category
+	}
+
+	switch {
+	case errors.Is(err, httpx.ErrUnauthorized), errors.Is(err, httpx.ErrForbidden):
+		return CategoryAuth
+	case errors.Is(err, httpx.ErrNotFound), errors.Is(err, httpx.ErrInvalidRequest):
+		// Usually a malformed server URL,
+		// or a budget, database or project that no longer exists
+		return CategoryConfig
+	case errors.Is(err, httpx.ErrRateLimited):
+		return CategoryRateLimit
+	case errors.Is(err, httpx.ErrServerError):
+		return CategoryUpstream
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, httpx.ErrStreamIdle):
+		return CategoryNetwork
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return CategoryNetwork
+	}
+
+	return CategoryUnknown
+}
@@ -0,0 +1,148 @@
+package inbox
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/samwho/streamdeck"
+)
+
+// fakeSettings names the key, so fetches can be told apart.
+type fakeSettings struct {
+	Name string `json:"name"`
+}
+
+// fakeService counts its fetches by settings name.
+type fakeService struct {
+	interval time.Duration
+
+	mu      sync.Mutex
+	fetches map[string]int
+}
+
+var _ Service[fakeSettings, int] = (*fakeService)(nil)
+
+func newFakeService(interval time.Duration) *fakeService {
+	return &fakeService{interval: interval, fetches: map[string]int{}}
+}
+
+func (f *fakeService) ActionUUID() string             { return "test.action" }
+func (f *fakeService) RefreshInterval() time.Duration { return f.interval }
+func (f *fakeService) LogPrefix() string              { return "[test]" }
+
+func (f *fakeService) ParseSettings(raw json.RawMessage) (fakeSettings, error) {
+	var settings fakeSettings
+	err := json.Unmarshal(raw, &settings)
+
+	return settings, err
+}
+
+func (f *fakeService) FetchResult(ctx context.Context, settings fakeSettings) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.fetches[settings.Name]++
+
+	return f.fetches[settings.Name], nil
+}
+
+func (f *fakeService) Render(ctx context.Context, client *streamdeck.Client, result int, err error) error {
+	return nil
+}
+
+func (f *fakeService) OpenURL(settings fakeSettings, result int) string {
+	return ""
+}
+
+// fetched returns how many times the settings with this name were fetched.
+func (f *fakeService) fetched(name string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.fetches[name]
+}
+
+// newFakeStreamDeck connects a client to a local websocket server that
+// accepts and discards everything the plugin sends.
+func newFakeStreamDeck(t *testing.T) *streamdeck.Client {
+	t.Helper()
+
+	registered := make(chan struct{})
+	var once sync.Once
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+			once.Do(func() { close(registered) })
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := streamdeck.NewClient(t.Context(), streamdeck.RegistrationParams{
+		Port:          portNumber,
+		PluginUUID:    "test-plugin",
+		RegisterEvent: "registerPlugin",
+	})
+	go func() { _ = client.Run() }()
+
+	// The client can only send once it has registered
+	select {
+	case <-registered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the client never registered")
+	}
+
+	return client
+}
+
+// settingsEvent is an event for key whose payload carries the named settings.
+func settingsEvent(t *testing.T, name, key, settingsName string) streamdeck.Event {
+	t.Helper()
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"settings": fakeSettings{Name: settingsName},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return streamdeck.Event{Event: name, Context: key, Payload: payload}
+}
+
+// eventually fails the test unless condition becomes true within a few seconds.
+func eventually(t *testing.T, message string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
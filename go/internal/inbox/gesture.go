@@ -0,0 +1,167 @@
+package inbox
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// LongPressThreshold is how long a key must be held to be a long press.
+	LongPressThreshold = 500 * time.Millisecond
+
+	// DoublePressWindow is how soon after a release the next press must
+	// start to count as a double press.
+	DoublePressWindow = 300 * time.Millisecond
+)
+
+// Gesture is how the user pressed a key.
+type Gesture int
+
+const (
+	GesturePress Gesture = iota
+	GestureLongPress
+	GestureDoublePress
+)
+
+func (g Gesture) String() string {
+	switch g {
+	case GesturePress:
+		return "press"
+	case GestureLongPress:
+		return "long press"
+	case GestureDoublePress:
+		return "double press"
+	default:
+		return "unknown gesture"
+	}
+}
+
+// KeyActionKind says what a key does when pressed.
+type KeyActionKind int
+
+const (
+	// KeyActionOpenURL opens Service.OpenURL, then refreshes. This is the default.
+	KeyActionOpenURL KeyActionKind = iota
+	// KeyActionOpenAlternateURL opens KeyAction.URL, then refreshes.
+	KeyActionOpenAlternateURL
+	// KeyActionRefresh shows the loading state and refreshes immediately,
+	// without opening anything. The key's next poll is a full interval later.
+	KeyActionRefresh
+	// KeyActionRun runs KeyAction.Run, e.g., "mark all as read", then refreshes.
+	// It runs in the background, with FetchTimeout to finish.
+	KeyActionRun
+	// KeyActionNone does nothing.
+	KeyActionNone
+)
+
+// KeyAction is what a key does for one gesture.
+type KeyAction struct {
+	Kind KeyActionKind
+	URL  string
+	Run  func(ctx context.Context) error
+}
+
+// GestureHandler is an optional interface for services that map
+// long presses and double presses to their own actions.
+// Without it, a long press refreshes and every other gesture opens Service.OpenURL.
+type GestureHandler[S any, R any] interface {
+	// HandleGesture returns the action to perform for the gesture.
+	// The result is the button's most recent good result.
+	HandleGesture(gesture Gesture, settings S, result R) KeyAction
+}
+
+// gestureTracker turns KeyDown and KeyUp events into gestures, per key.
+type gestureTracker struct {
+	mu   sync.Mutex
+	keys map[string]*keyPresses
+}
+
+type keyPresses struct {
+	downAt time.Time
+	// pending is a short press waiting to see whether a second press follows.
+	pending *time.Timer
+	// second is set when a press started while another was pending.
+	second bool
+}
+
+func newGestureTracker() *gestureTracker {
+	return &gestureTracker{keys: map[string]*keyPresses{}}
+}
+
+func (t *gestureTracker) presses(key string) *keyPresses {
+	kp, ok := t.keys[key]
+	if !ok {
+		kp = &keyPresses{}
+		t.keys[key] = kp
+	}
+
+	return kp
+}
+
+// keyDown records the start of a press.
+func (t *gestureTracker) keyDown(key string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	kp := t.presses(key)
+	kp.downAt = now
+	if kp.pending != nil && kp.pending.Stop() {
+		kp.pending = nil
+		kp.second = true
+	}
+}
+
+// keyUp classifies the press that just ended and calls fire with its gesture.
+// When detectDouble is set, a short press is held back for DoublePressWindow
+// in case a second press follows; fire is then called from another goroutine.
+func (t *gestureTracker) keyUp(key string, now time.Time, detectDouble bool, fire func(Gesture)) {
+	t.mu.Lock()
+
+	kp := t.presses(key)
+	held := time.Duration(0)
+	if !kp.downAt.IsZero() {
+		held = now.Sub(kp.downAt)
+	}
+	kp.downAt = time.Time{}
+
+	var gesture Gesture
+	switch {
+	case kp.second:
+		kp.second = false
+		gesture = GestureDoublePress
+	case held >= LongPressThreshold:
+		gesture = GestureLongPress
+	case !detectDouble:
+		gesture = GesturePress
+	default:
+		var timer *time.Timer
+		timer = time.AfterFunc(DoublePressWindow, func() {
+			t.mu.Lock()
+			if kp.pending == timer {
+				kp.pending = nil
+			}
+			t.mu.Unlock()
+
+			fire(GesturePress)
+		})
+		kp.pending = timer
+		t.mu.Unlock()
+
+		return
+	}
+
+	t.mu.Unlock()
+	fire(gesture)
+}
+
+// forget drops any state for key, including a press still waiting for a double.
+func (t *gestureTracker) forget(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if kp, ok := t.keys[key]; ok && kp.pending != nil {
+		kp.pending.Stop()
+	}
+	delete(t.keys, key)
+}
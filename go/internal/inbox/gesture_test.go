@@ -0,0 +1,185 @@
+package inbox
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// press is one KeyDown or KeyUp, at a number of milliseconds after the start.
+type press struct {
+	down bool
+	at   int
+}
+
+func TestGestureTracker(t *testing.T) {
+	tests := []struct {
+		name         string
+		detectDouble bool
+		presses      []press
+		// forget forgets the key after the presses.
+		forget bool
+		want   []Gesture
+	}{
+		{
+			name:    "a short press fires straight away without double-press detection",
+			presses: []press{{down: true, at: 0}, {at: 100}},
+			want:    []Gesture{GesturePress},
+		},
+		{
+			name:         "a short press waits for a possible double press",
+			detectDouble: true,
+			presses:      []press{{down: true, at: 0}, {at: 100}},
+			want:         []Gesture{GesturePress},
+		},
+		{
+			name:         "a long press",
+			detectDouble: true,
+			presses:      []press{{down: true, at: 0}, {at: 600}},
+			want:         []Gesture{GestureLongPress},
+		},
+		{
+			name:         "a double press",
+			detectDouble: true,
+			presses:      []press{{down: true, at: 0}, {at: 80}, {down: true, at: 150}, {at: 220}},
+			want:         []Gesture{GestureDoublePress},
+		},
+		{
+			name:    "two presses are two short presses without double-press detection",
+			presses: []press{{down: true, at: 0}, {at: 80}, {down: true, at: 150}, {at: 220}},
+			want:    []Gesture{GesturePress, GesturePress},
+		},
+		{
+			name:         "a release without a press is a short press",
+			detectDouble: true,
+			presses:      []press{{at: 0}},
+			want:         []Gesture{GesturePress},
+		},
+		{
+			name:         "forgetting the key drops a pending press",
+			detectDouble: true,
+			presses:      []press{{down: true, at: 0}, {at: 100}},
+			forget:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			tracker := newGestureTracker()
+			start := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+			var mu sync.Mutex
+			var fired []Gesture
+			fire := func(gesture Gesture) {
+				mu.Lock()
+				defer mu.Unlock()
+				fired = append(fired, gesture)
+			}
+
+			for _, p := range tt.presses {
+				now := start.Add(time.Duration(p.at) * time.Millisecond)
+				if p.down {
+					tracker.keyDown("key", now)
+				} else {
+					tracker.keyUp("key", now, tt.detectDouble, fire)
+				}
+			}
+			if tt.forget {
+				tracker.forget("key")
+			}
+
+			// Let any pending short press fire
+			time.Sleep(DoublePressWindow + 100*time.Millisecond)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if !slices.Equal(fired, tt.want) {
+				t.Errorf("fired %v, want %v", fired, tt.want)
+			}
+		})
+	}
+}
+
+// fakeGestures is a fakeService with fixed actions for a short and a double press.
+type fakeGestures struct {
+	*fakeService
+
+	press, double KeyAction
+}
+
+var _ GestureHandler[fakeSettings, int] = (*fakeGestures)(nil)
+
+func (f *fakeGestures) HandleGesture(gesture Gesture, settings fakeSettings, result int) KeyAction {
+	if gesture == GestureDoublePress {
+		return f.double
+	}
+
+	return f.press
+}
+
+func TestDetectDouble(t *testing.T) {
+	run := KeyAction{Kind: KeyActionRun, Run: func(context.Context) error { return nil }}
+	open := KeyAction{Kind: KeyActionOpenURL}
+
+	tests := []struct {
+		name string
+		// svc is nil for a service without a GestureHandler.
+		svc    *fakeGestures
+		common CommonSettings
+		want   bool
+	}{
+		{
+			name: "a service without a GestureHandler",
+			want: false,
+		},
+		{
+			name: "a double press that does nothing",
+			svc:  &fakeGestures{press: open, double: KeyAction{Kind: KeyActionNone}},
+			want: false,
+		},
+		{
+			name: "a double press that opens the same URL",
+			svc:  &fakeGestures{press: open, double: open},
+			want: false,
+		},
+		{
+			name: "a double press that opens another URL",
+			svc: &fakeGestures{
+				press:  open,
+				double: KeyAction{Kind: KeyActionOpenAlternateURL, URL: "https://example.com/budget"},
+			},
+			want: true,
+		},
+		{
+			name: "a double press that runs an action",
+			svc:  &fakeGestures{press: open, double: run},
+			want: true,
+		},
+		{
+			name:   "a short press that only refreshes",
+			svc:    &fakeGestures{press: open, double: open},
+			common: CommonSettings{PressAction: PressActionRefresh},
+			want:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got bool
+			if tt.svc == nil {
+				got = newHandlers(newFakeService(time.Hour)).detectDouble(fakeSettings{}, tt.common, 0)
+			} else {
+				tt.svc.fakeService = newFakeService(time.Hour)
+				got = newHandlers[fakeSettings, int](tt.svc).detectDouble(fakeSettings{}, tt.common, 0)
+			}
+
+			if got != tt.want {
+				t.Errorf("detectDouble() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
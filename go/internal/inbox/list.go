@@ -0,0 +1,33 @@
+package inbox
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// OneOrMany is a list that also accepts a single value,
+// because the property inspector sends one selected option as a string.
+// A single zero value, e.g., "", is an empty list.
+type OneOrMany[T comparable] []T
+
+func (l *OneOrMany[T]) UnmarshalJSON(data []byte) error {
+	var list []T
+	if err := json.Unmarshal(data, &list); err == nil {
+		*l = list
+
+		return nil
+	}
+
+	var single T
+	if err := json.Unmarshal(data, &single); err != nil {
+		return fmt.Errorf("must be a list or a single value: %w", err)
+	}
+
+	var zero T
+	*l = nil
+	if single != zero {
+		*l = OneOrMany[T]{single}
+	}
+
+	return nil
+}
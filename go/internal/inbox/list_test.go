@@ -0,0 +1,45 @@
+package inbox
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestOneOrManyUnmarshalJSON(t *testing.T) {
+	type category string
+
+	tests := []struct {
+		name    string
+		json    string
+		want    []category
+		wantErr bool
+	}{
+		{name: "list", json: `["a","b"]`, want: []category{"a", "b"}},
+		{name: "empty list", json: `[]`, want: []category{}},
+		{name: "single value", json: `"a"`, want: []category{"a"}},
+		{name: "blank value", json: `""`, want: nil},
+		{name: "null", json: `null`, want: nil},
+		{name: "wrong type", json: `7`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got OneOrMany[category]
+			err := json.Unmarshal([]byte(tt.json), &got)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("got %v, want an error", got)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) || (got == nil) != (tt.want == nil) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
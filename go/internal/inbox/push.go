@@ -0,0 +1,24 @@
+package inbox
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrPushUnsupported is returned by Pusher.Watch when the server cannot push
+// updates. The key then falls back to polling FetchResult.
+var ErrPushUnsupported = errors.New("push updates are not supported by the server")
+
+// errPushEnded is the error of a push session that ended without one.
+var errPushEnded = errors.New("the push session ended")
+
+// Pusher is an optional interface for services that can keep a connection
+// open and push results as they change, instead of being polled.
+type Pusher[S any, R any] interface {
+	// Watch pushes the current result as soon as it is known, and again
+	// whenever it changes, until ctx is cancelled or the connection fails.
+	// push may be called from any goroutine, but not after Watch returns.
+	// Watch is called again after the key's refresh interval when it returns,
+	// backing off while sessions keep ending.
+	Watch(ctx context.Context, settings S, push func(result R, err error)) error
+}
@@ -0,0 +1,115 @@
+package inbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/samwho/streamdeck"
+)
+
+// fakePusher is a fakeService that is watched instead of polled.
+// Each watch pushes once, then ends with watchErr.
+type fakePusher struct {
+	*fakeService
+
+	watchErr error
+
+	mu sync.Mutex
+	// watches are the times Watch was called.
+	watches []time.Time
+}
+
+var _ Pusher[fakeSettings, int] = (*fakePusher)(nil)
+
+func (f *fakePusher) Watch(ctx context.Context, settings fakeSettings, push func(int, error)) error {
+	f.mu.Lock()
+	f.watches = append(f.watches, time.Now())
+	f.mu.Unlock()
+
+	if errors.Is(f.watchErr, ErrPushUnsupported) {
+		return f.watchErr
+	}
+	push(100, nil)
+
+	return f.watchErr
+}
+
+func (f *fakePusher) watched() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.watches)
+}
+
+func TestPushSessions(t *testing.T) {
+	tests := []struct {
+		name     string
+		watchErr error
+		check    func(t *testing.T, svc *fakePusher)
+	}{
+		{
+			name:     "a dropped push session reconnects",
+			watchErr: errors.New("event stream went quiet"),
+			check: func(t *testing.T, svc *fakePusher) {
+				eventually(t, "the key should keep reconnecting", func() bool {
+					return svc.watched() >= 3
+				})
+				if got := svc.fetched("a"); got != 0 {
+					t.Errorf("polled %d times while push works", got)
+				}
+			},
+		},
+		{
+			name:     "reconnecting backs off while sessions keep dropping",
+			watchErr: nil,
+			check: func(t *testing.T, svc *fakePusher) {
+				eventually(t, "the key should keep reconnecting", func() bool {
+					return svc.watched() >= 4
+				})
+
+				svc.mu.Lock()
+				defer svc.mu.Unlock()
+				for i := 1; i < 4; i++ {
+					gap := svc.watches[i].Sub(svc.watches[i-1])
+					if want := svc.interval << i; gap < want {
+						t.Errorf("reconnect %d came after %v, want at least %v", i, gap, want)
+					}
+				}
+			},
+		},
+		{
+			name:     "a server that cannot push is polled instead",
+			watchErr: ErrPushUnsupported,
+			check: func(t *testing.T, svc *fakePusher) {
+				eventually(t, "the key should fall back to polling", func() bool {
+					return svc.fetched("a") >= 3
+				})
+				if got := svc.watched(); got != 1 {
+					t.Errorf("watched %d times, want once before falling back", got)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeStreamDeck(t)
+			svc := &fakePusher{fakeService: newFakeService(10 * time.Millisecond), watchErr: tt.watchErr}
+			h := newHandlers[fakeSettings, int](svc)
+			ctx, cancel := context.WithCancel(t.Context())
+			defer cancel()
+
+			if err := h.willAppear(ctx, client, settingsEvent(t, streamdeck.WillAppear, "key-a", "a")); err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = h.willDisappear(ctx, client, settingsEvent(t, streamdeck.WillDisappear, "key-a", "a"))
+			}()
+
+			tt.check(t, svc)
+		})
+	}
+}
@@ -3,213 +3,487 @@
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/url"
+	"sync/atomic"
 	"time"
 
 	"github.com/samwho/streamdeck"
-	sdcontext "github.com/samwho/streamdeck/context"
 )
 
+// FetchTimeout is the deadline for a single FetchResult call.
+// A hung server must not stall a button's poller forever.
+const FetchTimeout = 30 * time.Second
+
 // Register sets up all Stream Deck event handlers for a service.
 // The type parameters match the service's settings and result types,
 // providing compile-time type safety throughout the event handlers.
 func Register[S any, R any](client *streamdeck.Client, svc Service[S, R]) {
 	action := client.Action(svc.ActionUUID())
+	h := newHandlers(svc)
+
+	action.RegisterHandler(streamdeck.WillAppear, h.willAppear)
+	action.RegisterHandler(streamdeck.WillDisappear, h.willDisappear)
+	action.RegisterHandler(streamdeck.DidReceiveSettings, h.didReceiveSettings)
+	action.RegisterHandler(streamdeck.KeyDown, h.keyDown)
+	action.RegisterHandler(streamdeck.KeyUp, h.keyUp)
 
-	// buttonState holds per-button state with the service's concrete types
-	type buttonState struct {
-		settings S
-		result   R
+	// Check if service supports SendToPlugin handling for property inspector communication
+	if handler, ok := any(svc).(SendToPluginHandler[S]); ok {
+		action.RegisterHandler(streamdeck.SendToPlugin, h.sendToPlugin(handler))
 	}
-	storage := map[string]*buttonState{}
-	var quit chan struct{}
+}
 
-	logPrefix := svc.LogPrefix()
+// handlers holds the state shared by every event handler of one action.
+type handlers[S any, R any] struct {
+	svc       Service[S, R]
+	store     *Store[S, R]
+	scheduler *Scheduler
+	gestures  *gestureTracker
+	logPrefix string
+}
 
-	action.RegisterHandler(
-		streamdeck.WillAppear,
-		func(ctx context.Context, client *streamdeck.Client, event streamdeck.Event) error {
-			p := streamdeck.WillAppearPayload{}
-			if err := json.Unmarshal(event.Payload, &p); err != nil {
-				return err
-			}
+func newHandlers[S any, R any](svc Service[S, R]) *handlers[S, R] {
+	return &handlers[S, R]{
+		svc:       svc,
+		store:     NewStore[S, R](),
+		scheduler: NewScheduler(),
+		gestures:  newGestureTracker(),
+		logPrefix: svc.LogPrefix(),
+	}
+}
 
-			settings, err := svc.ParseSettings(p.Settings)
-			if err != nil {
-				return err
-			}
-			storage[event.Context] = &buttonState{settings: settings}
+func (h *handlers[S, R]) willAppear(
+	ctx context.Context,
+	client *streamdeck.Client,
+	event streamdeck.Event,
+) error {
+	p := streamdeck.WillAppearPayload{}
+	if err := json.Unmarshal(event.Payload, &p); err != nil {
+		return err
+	}
 
-			// Show loading state
-			if err := SetLoading(ctx, client); err != nil {
-				return logError(logPrefix, event, err)
-			}
+	settings, common, err := h.parseSettings(p.Settings)
+	if err != nil {
+		return err
+	}
+	h.store.Add(event.Context, settings, common)
 
-			// Start polling
-			ticker := time.NewTicker(svc.RefreshInterval())
-			quit = make(chan struct{})
-
-			go func() {
-				// Initial fetch
-				ctxStr := event.Context
-				localCtx := sdcontext.WithContext(context.Background(), ctxStr)
-				localSettings := settings
-
-				result, fetchErr := svc.FetchResult(localCtx, localSettings)
-				if state, ok := storage[ctxStr]; ok {
-					state.result = result
-				}
-				if renderErr := svc.Render(localCtx, client, result, fetchErr); renderErr != nil {
-					log.Printf("%s render error: %v", logPrefix, renderErr)
-				}
-
-				// Periodic updates
-				for {
-					select {
-					case <-ticker.C:
-						for ctxStr, state := range storage {
-							ctx := sdcontext.WithContext(context.Background(), ctxStr)
-							result, fetchErr := svc.FetchResult(ctx, state.settings)
-							state.result = result
-							if renderErr := svc.Render(ctx, client, result, fetchErr); renderErr != nil {
-								log.Printf("%s render error: %v", logPrefix, renderErr)
-							}
-						}
-					case <-quit:
-						ticker.Stop()
-
-						return
-					}
-				}
-			}()
-
-			return nil
-		},
-	)
-
-	action.RegisterHandler(
-		streamdeck.WillDisappear,
-		func(ctx context.Context, client *streamdeck.Client, event streamdeck.Event) error {
-			delete(storage, event.Context)
-			if quit != nil {
-				close(quit)
-			}
+	// Show loading state
+	if err := SetLoading(ctx, client); err != nil {
+		return logError(h.logPrefix, event, err)
+	}
 
-			return nil
-		},
-	)
+	h.startPolling(ctx, client, event.Context)
 
-	action.RegisterHandler(
-		streamdeck.DidReceiveSettings,
-		func(ctx context.Context, client *streamdeck.Client, event streamdeck.Event) error {
-			p := streamdeck.DidReceiveSettingsPayload{}
-			if err := json.Unmarshal(event.Payload, &p); err != nil {
-				return err
-			}
+	return nil
+}
 
-			settings, err := svc.ParseSettings(p.Settings)
-			if err != nil {
-				return err
-			}
+func (h *handlers[S, R]) willDisappear(
+	ctx context.Context,
+	client *streamdeck.Client,
+	event streamdeck.Event,
+) error {
+	// The key leaves the store before its poller stops, so a poller being
+	// started at the same time either finds the key gone or is stopped here
+	h.store.Delete(event.Context)
+	h.scheduler.Stop(event.Context)
+	h.gestures.forget(event.Context)
+
+	return nil
+}
 
-			if state, ok := storage[event.Context]; ok {
-				state.settings = settings
-			} else {
-				storage[event.Context] = &buttonState{settings: settings}
-			}
+func (h *handlers[S, R]) didReceiveSettings(
+	ctx context.Context,
+	client *streamdeck.Client,
+	event streamdeck.Event,
+) error {
+	p := streamdeck.DidReceiveSettingsPayload{}
+	if err := json.Unmarshal(event.Payload, &p); err != nil {
+		return err
+	}
 
-			result, fetchErr := svc.FetchResult(ctx, settings)
-			if state, ok := storage[event.Context]; ok {
-				state.result = result
-			}
-			if renderErr := svc.Render(ctx, client, result, fetchErr); renderErr != nil {
-				return logError(logPrefix, event, renderErr)
-			}
+	settings, common, err := h.parseSettings(p.Settings)
+	if err != nil {
+		return err
+	}
+	if !h.store.SetSettings(event.Context, settings, common) {
+		// The key went away before its new settings arrived
+		return nil
+	}
 
-			return nil
-		},
-	)
+	// Restarting the poller cancels any fetch using the old settings,
+	// then fetches immediately with the new ones
+	h.startPolling(ctx, client, event.Context)
 
-	action.RegisterHandler(
-		streamdeck.KeyUp,
-		func(ctx context.Context, client *streamdeck.Client, event streamdeck.Event) error {
-			p := streamdeck.KeyUpPayload{}
-			if err := json.Unmarshal(event.Payload, &p); err != nil {
-				return err
-			}
+	return nil
+}
 
-			settings, err := svc.ParseSettings(p.Settings)
-			if err != nil {
-				return err
-			}
+func (h *handlers[S, R]) keyDown(
+	ctx context.Context,
+	client *streamdeck.Client,
+	event streamdeck.Event,
+) error {
+	h.gestures.keyDown(event.Context, time.Now())
 
-			var result R
-			if state, ok := storage[event.Context]; ok {
-				result = state.result
-			}
+	return nil
+}
 
-			urlStr := svc.OpenURL(settings, result)
-			if urlStr != "" {
-				parsedURL, err := url.Parse(urlStr)
-				if err != nil {
-					return logError(logPrefix, event, err)
-				}
-				if err := client.OpenURL(ctx, *parsedURL); err != nil {
-					return logError(logPrefix, event, err)
-				}
-			}
+func (h *handlers[S, R]) keyUp(
+	ctx context.Context,
+	client *streamdeck.Client,
+	event streamdeck.Event,
+) error {
+	p := streamdeck.KeyUpPayload{}
+	if err := json.Unmarshal(event.Payload, &p); err != nil {
+		return err
+	}
 
-			// Refresh after click
-			result, fetchErr := svc.FetchResult(ctx, settings)
-			if state, ok := storage[event.Context]; ok {
-				state.result = result
-			}
-			if renderErr := svc.Render(ctx, client, result, fetchErr); renderErr != nil {
-				return logError(logPrefix, event, renderErr)
+	settings, common, err := h.parseSettings(p.Settings)
+	if err != nil {
+		return err
+	}
+	if !h.store.SetSettings(event.Context, settings, common) {
+		return nil
+	}
+
+	var result R
+	if state, ok := h.store.Get(event.Context); ok {
+		result = state.Current()
+	}
+	detectDouble := h.detectDouble(settings, common, result)
+
+	h.gestures.keyUp(event.Context, time.Now(), detectDouble, func(gesture Gesture) {
+		if err := h.perform(ctx, client, event.Context, gesture, settings, common); err != nil {
+			_ = logError(h.logPrefix, event, err)
+		}
+	})
+
+	return nil
+}
+
+// perform carries out the key action for a gesture, then refreshes the button.
+func (h *handlers[S, R]) perform(
+	ctx context.Context,
+	client *streamdeck.Client,
+	key string,
+	gesture Gesture,
+	settings S,
+	common CommonSettings,
+) error {
+	var result R
+	if state, ok := h.store.Get(key); ok {
+		result = state.Current()
+	}
+
+	action := h.keyAction(gesture, settings, common, result)
+
+	var actionErr error
+	switch action.Kind {
+	case KeyActionNone:
+		return nil
+	case KeyActionOpenURL:
+		actionErr = openURL(ctx, client, h.svc.OpenURL(settings, result))
+	case KeyActionOpenAlternateURL:
+		actionErr = openURL(ctx, client, action.URL)
+	case KeyActionRun:
+		if action.Run != nil {
+			// Handlers run on the plugin's only event loop, which must not
+			// wait for a login and a STORE
+			go h.run(ctx, client, key, action.Run)
+		}
+
+		return nil
+	case KeyActionRefresh:
+		h.forceRefresh(ctx, client, key)
+
+		return nil
+	}
+
+	// Refresh after click. Restarting the poller also cancels any fetch
+	// already in flight, and pushes back the next scheduled poll.
+	h.startPolling(ctx, client, key)
+
+	return actionErr
+}
+
+// run carries out a KeyActionRun within FetchTimeout, shows whether it
+// worked, then refreshes the button.
+func (h *handlers[S, R]) run(
+	ctx context.Context,
+	client *streamdeck.Client,
+	key string,
+	run func(ctx context.Context) error,
+) {
+	runCtx, cancel := context.WithTimeout(ctx, FetchTimeout)
+	defer cancel()
+
+	if err := run(runCtx); err != nil {
+		log.Printf("%s key action error: %v", h.logPrefix, err)
+		_ = client.ShowAlert(ctx)
+	} else {
+		_ = client.ShowOk(ctx)
+	}
+
+	h.startPolling(ctx, client, key)
+}
+
+// detectDouble reports whether a double press on this key would do
+// something a short press doesn't. Only then does a short press wait
+// DoublePressWindow to see whether a second press follows.
+func (h *handlers[S, R]) detectDouble(settings S, common CommonSettings, result R) bool {
+	if _, ok := any(h.svc).(GestureHandler[S, R]); !ok {
+		return false
+	}
+
+	double := h.keyAction(GestureDoublePress, settings, common, result)
+	if double.Kind == KeyActionNone {
+		return false
+	}
+	press := h.keyAction(GesturePress, settings, common, result)
+
+	// Run funcs can't be compared, so two of them are taken to differ
+	return double.Kind != press.Kind || double.URL != press.URL || double.Run != nil
+}
+
+// keyAction chooses what a gesture does. A short press follows the key's
+// PressAction setting; other gestures are up to the service's GestureHandler,
+// falling back to refreshing on a long press.
+func (h *handlers[S, R]) keyAction(gesture Gesture, settings S, common CommonSettings, result R) KeyAction {
+	if gesture == GesturePress && common.PressAction == PressActionRefresh {
+		return KeyAction{Kind: KeyActionRefresh}
+	}
+
+	if handler, ok := any(h.svc).(GestureHandler[S, R]); ok {
+		return handler.HandleGesture(gesture, settings, result)
+	}
+
+	if gesture == GestureLongPress {
+		return KeyAction{Kind: KeyActionRefresh}
+	}
+
+	return KeyAction{Kind: KeyActionOpenURL}
+}
+
+// forceRefresh re-checks a button right now, showing the loading state
+// until the fetch completes, and restarts its poll timer.
+func (h *handlers[S, R]) forceRefresh(ctx context.Context, client *streamdeck.Client, key string) {
+	if err := SetLoading(ctx, client); err != nil {
+		log.Printf("%s set loading error: %v", h.logPrefix, err)
+	}
+
+	h.startPolling(ctx, client, key)
+}
+
+func openURL(ctx context.Context, client *streamdeck.Client, urlStr string) error {
+	if urlStr == "" {
+		return nil
+	}
+
+	parsedURL, err := url.Parse(urlStr)
+	if err != nil {
+		return err
+	}
+
+	return client.OpenURL(ctx, *parsedURL)
+}
+
+func (h *handlers[S, R]) sendToPlugin(handler SendToPluginHandler[S]) streamdeck.EventHandler {
+	return func(ctx context.Context, client *streamdeck.Client, event streamdeck.Event) error {
+		// Parse the payload to extract settings
+		var payload struct {
+			Settings json.RawMessage `json:"settings"`
+		}
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return err
+		}
+
+		settings, err := h.svc.ParseSettings(payload.Settings)
+		if err != nil {
+			// Send error response back to PI
+			return client.SendToPropertyInspector(ctx, map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+
+		// The property inspector's requests run on the event loop,
+		// so a hung server must not hold up every other key
+		handlerCtx, cancel := context.WithTimeout(ctx, FetchTimeout)
+		defer cancel()
+
+		response, err := handler.HandleSendToPlugin(handlerCtx, client, event.Payload, settings)
+		if err != nil {
+			log.Printf("%s SendToPlugin error: %v", h.logPrefix, err)
+
+			return client.SendToPropertyInspector(ctx, map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+
+		if response != nil {
+			return client.SendToPropertyInspector(ctx, response)
+		}
+
+		return nil
+	}
+}
+
+// parseSettings parses both the service's settings and the common settings.
+func (h *handlers[S, R]) parseSettings(raw json.RawMessage) (S, CommonSettings, error) {
+	settings, err := h.svc.ParseSettings(raw)
+	if err != nil {
+		return settings, CommonSettings{}, err
+	}
+
+	common, err := ParseCommonSettings(raw)
+	if err != nil {
+		return settings, CommonSettings{}, err
+	}
+
+	return settings, common, nil
+}
+
+// refreshInterval is the poll interval for a key with the given settings.
+func (h *handlers[S, R]) refreshInterval(common CommonSettings) time.Duration {
+	minimum := DefaultMinRefreshInterval
+	if provider, ok := any(h.svc).(MinRefreshIntervalProvider); ok {
+		minimum = provider.MinRefreshInterval()
+	}
+
+	return common.RefreshInterval(h.svc.RefreshInterval(), minimum)
+}
+
+// startPolling (re)starts the poller for one button at its refresh interval.
+// Each poll reads the button's current settings from the store,
+// so the poller always uses the latest settings.
+// Services that implement Pusher are watched instead of polled,
+// until they report that the server cannot push.
+// Nothing is started for a key that has gone away, even one that goes
+// away while this runs, and a poller stops once its key is gone.
+func (h *handlers[S, R]) startPolling(ctx context.Context, client *streamdeck.Client, key string) {
+	state, ok := h.store.Get(key)
+	if !ok {
+		return
+	}
+
+	// Only this key's poller goroutine reads or writes canPush
+	pusher, canPush := any(h.svc).(Pusher[S, R])
+
+	interval := h.refreshInterval(state.Common)
+	h.scheduler.Start(ctx, key, interval, func(ctx context.Context) (time.Duration, error) {
+		state, ok := h.store.Get(key)
+		if !ok {
+			return 0, errKeyGone
+		}
+
+		if canPush {
+			wait, err := h.watch(ctx, client, key, pusher, state.Settings)
+			if !errors.Is(err, ErrPushUnsupported) {
+				return wait, err
 			}
+			log.Printf("%s falling back to polling: %v", h.logPrefix, err)
+			canPush = false
+		}
 
-			return nil
-		},
-	)
+		return h.refresh(ctx, client, key, state.Settings)
+	}, func() bool {
+		_, ok := h.store.Get(key)
 
-	// Check if service supports SendToPlugin handling for property inspector communication
-	if handler, ok := any(svc).(SendToPluginHandler[S]); ok {
-		action.RegisterHandler(
-			streamdeck.SendToPlugin,
-			func(ctx context.Context, client *streamdeck.Client, event streamdeck.Event) error {
-				// Parse the payload to extract settings
-				var payload struct {
-					Settings json.RawMessage `json:"settings"`
-				}
-				if err := json.Unmarshal(event.Payload, &payload); err != nil {
-					return err
-				}
-
-				settings, err := svc.ParseSettings(payload.Settings)
-				if err != nil {
-					// Send error response back to PI
-					return client.SendToPropertyInspector(ctx, map[string]interface{}{
-						"error": err.Error(),
-					})
-				}
-
-				response, err := handler.HandleSendToPlugin(ctx, client, event.Payload, settings)
-				if err != nil {
-					log.Printf("%s SendToPlugin error: %v", logPrefix, err)
-
-					return client.SendToPropertyInspector(ctx, map[string]interface{}{
-						"error": err.Error(),
-					})
-				}
-
-				if response != nil {
-					return client.SendToPropertyInspector(ctx, response)
-				}
-
-				return nil
-			},
-		)
+		return ok
+	})
+}
+
+// refresh fetches the result for one button, stores it, and renders it.
+// Nothing is stored or rendered if the poll was cancelled during the fetch,
+// because the button went away or its settings changed.
+// It returns the fetch error and any wait the upstream API asked for,
+// so the scheduler can back off.
+func (h *handlers[S, R]) refresh(
+	ctx context.Context,
+	client *streamdeck.Client,
+	key string,
+	settings S,
+) (time.Duration, error) {
+	fetchCtx, cancel := context.WithTimeout(ctx, FetchTimeout)
+	defer cancel()
+
+	result, fetchErr := h.svc.FetchResult(fetchCtx, settings)
+	if ctx.Err() != nil {
+		return 0, nil
+	}
+	h.update(ctx, client, key, result, fetchErr)
+
+	return retryAfter(result, fetchErr), fetchErr
+}
+
+// watch runs one push session for a button. A session that ends on its own
+// is a failure, so reconnecting backs off while the server keeps dropping
+// them, unless it stayed up for MaxBackoff. One that failed before pushing
+// anything is also shown on the button as a failed fetch.
+func (h *handlers[S, R]) watch(
+	ctx context.Context,
+	client *streamdeck.Client,
+	key string,
+	pusher Pusher[S, R],
+	settings S,
+) (time.Duration, error) {
+	var pushed atomic.Bool
+	start := time.Now()
+	watchErr := pusher.Watch(ctx, settings, func(result R, err error) {
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			pushed.Store(true)
+		}
+		h.update(ctx, client, key, result, err)
+	})
+
+	switch {
+	case ctx.Err() != nil:
+		return 0, nil
+	case errors.Is(watchErr, ErrPushUnsupported):
+		return 0, watchErr
+	case watchErr == nil:
+		watchErr = WrapError(CategoryNetwork, errPushEnded)
+	}
+
+	if pushed.Load() {
+		log.Printf("%s push session ended: %v", h.logPrefix, watchErr)
+		if time.Since(start) >= MaxBackoff {
+			return 0, nil
+		}
+
+		// The button keeps showing the last pushed result
+		return retryAfter(nil, watchErr), watchErr
+	}
+
+	var zero R
+	h.update(ctx, client, key, zero, watchErr)
+
+	return retryAfter(nil, watchErr), watchErr
+}
+
+// update stores a fetched or pushed result for one button and renders it.
+// A transient failure keeps showing the last good result, marked as stale,
+// until the key's StalePolicy runs out.
+func (h *handlers[S, R]) update(
+	ctx context.Context,
+	client *streamdeck.Client,
+	key string,
+	result R,
+	fetchErr error,
+) {
+	now := time.Now()
+	state, ok := h.store.SetResult(key, result, fetchErr, now)
+	if !ok {
+		return
+	}
+
+	shown, renderErr := present(state, state.Common.StalePolicy(), now)
+	if err := h.svc.Render(ctx, client, shown, renderErr); err != nil {
+		log.Printf("%s render error: %v", h.logPrefix, err)
 	}
 }
 
@@ -15,6 +15,10 @@
 	GoldState    = 1
 )
 
+// StaleMarker is appended to the title of a button showing an old result
+// because its latest fetches failed.
+const StaleMarker = "~"
+
 // SetLoading displays a loading indicator on the button.
 func SetLoading(ctx context.Context, client *streamdeck.Client) error {
 	err := client.SetTitle(ctx, display.PadRight("..."), streamdeck.HardwareAndSoftware)
@@ -29,50 +33,64 @@ func SetLoading(ctx context.Context, client *streamdeck.Client) error {
 	return nil
 }
 
+// RenderError shows the glyph for the error's category on the button.
+// It returns err, wrapped with any error from updating the button.
+func RenderError(ctx context.Context, client *streamdeck.Client, err error) error {
+	glyph := Categorize(err).Glyph()
+
+	newErr := client.SetTitle(ctx, display.PadRight(glyph), streamdeck.HardwareAndSoftware)
+	if newErr != nil {
+		return fmt.Errorf("error setting title: %w  -- %w", newErr, err)
+	}
+
+	newErr = client.SetState(ctx, DefaultState)
+	if newErr != nil {
+		return fmt.Errorf("error setting state: %w  -- %w", newErr, err)
+	}
+
+	return err
+}
+
 // RenderCount is the standard renderer for single-count services.
 // Use this in your Service.Render implementation for simple inbox types.
+// A stale count is shown with StaleMarker after it.
 func RenderCount(ctx context.Context, client *streamdeck.Client, count uint, err error) error {
-	if err != nil {
-		newErr := client.SetTitle(ctx, display.PadRight("!"), streamdeck.HardwareAndSoftware)
-		if newErr != nil {
-			return fmt.Errorf("error setting title: %w  -- %w", newErr, err)
-		}
+	if err != nil && !IsStale(err) {
+		return RenderError(ctx, client, err)
+	}
 
-		newErr = client.SetState(ctx, DefaultState)
-		if newErr != nil {
-			return fmt.Errorf("error setting state: %w  -- %w", newErr, err)
-		}
+	title, state := countDisplay(count, IsStale(err))
+	setErr := client.SetState(ctx, state)
+	if setErr != nil {
+		log.Println("error while setting state", setErr)
 
-		return err
+		return setErr
 	}
+	setErr = client.SetTitle(ctx, title, streamdeck.HardwareAndSoftware)
+	if setErr != nil {
+		log.Println("error while setting icon title with unseen count", setErr)
 
-	if count == 0 {
-		setErr := client.SetState(ctx, GoldState)
-		if setErr != nil {
-			log.Println("error while setting state", setErr)
+		return setErr
+	}
 
-			return setErr
-		}
-		setErr = client.SetTitle(ctx, "", streamdeck.HardwareAndSoftware)
-		if setErr != nil {
-			log.Println("error while setting icon title with unseen count", setErr)
+	return nil
+}
 
-			return setErr
-		}
-	} else {
-		setErr := client.SetState(ctx, DefaultState)
-		if setErr != nil {
-			log.Println("error while setting state", setErr)
+// countDisplay returns the title and state RenderCount shows for a count:
+// gold with no title for zero, otherwise the count, with StaleMarker if stale.
+func countDisplay(count uint, stale bool) (string, int) {
+	marker := ""
+	if stale {
+		marker = StaleMarker
+	}
 
-			return setErr
+	if count == 0 {
+		if marker == "" {
+			return "", GoldState
 		}
-		setErr = client.SetTitle(ctx, display.PadRight(strconv.FormatUint(uint64(count), 10)), streamdeck.HardwareAndSoftware)
-		if setErr != nil {
-			log.Println("error while setting icon title with unseen count", setErr)
 
-			return setErr
-		}
+		return display.PadRight(marker), GoldState
 	}
 
-	return nil
+	return display.PadRight(strconv.FormatUint(uint64(count), 10) + marker), DefaultState
 }
@@ -0,0 +1,113 @@
+package inbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// errKeyGone is returned by a PollFunc whose button has gone away.
+// It ends the poller, which would otherwise poll a missing key forever.
+var errKeyGone = errors.New("the key has gone away")
+
+// PollFunc fetches and renders the current state of a single button.
+// The context is cancelled when the button's poller is stopped.
+// It returns the fetch error, if any, and how long the upstream API asked
+// us to wait before the next request (zero if it did not say).
+type PollFunc func(ctx context.Context) (retryAfter time.Duration, err error)
+
+// Scheduler runs one poller per button context.
+// Each poller has its own cancellation, so keys can appear and disappear
+// independently without affecting the refreshes of the other keys.
+type Scheduler struct {
+	mu      sync.Mutex
+	pollers map[string]*poller
+}
+
+type poller struct {
+	cancel context.CancelFunc
+}
+
+// NewScheduler returns an empty Scheduler.
+func NewScheduler() *Scheduler {
+	return &Scheduler{pollers: map[string]*poller{}}
+}
+
+// Start begins polling for the button identified by key.
+// The poll function runs once immediately and then every interval until the
+// poller is stopped, backing off while polls fail (see nextDelay).
+// Starting a key that is already running replaces its poller.
+//
+// exists is checked under the scheduler's lock, and nothing is started if it
+// reports false. Callers remove a key from their own state before calling
+// Stop, so a Start racing with that removal either sees the key gone or
+// starts a poller that Stop then cancels. Start reports whether it started.
+func (s *Scheduler) Start(
+	ctx context.Context,
+	key string,
+	interval time.Duration,
+	poll PollFunc,
+	exists func() bool,
+) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !exists() {
+		return false
+	}
+
+	if existing, ok := s.pollers[key]; ok {
+		existing.cancel()
+	}
+
+	pollCtx, cancel := context.WithCancel(ctx)
+	p := &poller{cancel: cancel}
+	s.pollers[key] = p
+
+	go p.run(pollCtx, interval, poll)
+
+	return true
+}
+
+// Stop cancels the poller for key. It reports whether a poller was running.
+// Stopping an unknown key is a no-op, so duplicate WillDisappear events are harmless.
+func (s *Scheduler) Stop(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.pollers[key]
+	if !ok {
+		return false
+	}
+	p.cancel()
+	delete(s.pollers, key)
+
+	return true
+}
+
+func (p *poller) run(ctx context.Context, interval time.Duration, poll PollFunc) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return
+		}
+
+		retryAfter, err := poll(ctx)
+		if errors.Is(err, errKeyGone) {
+			return
+		}
+		if err != nil {
+			failures++
+		} else {
+			failures = 0
+		}
+
+		timer.Reset(nextDelay(interval, failures, retryAfter))
+	}
+}
@@ -0,0 +1,262 @@
+package inbox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/samwho/streamdeck"
+)
+
+// keyEvent is a Stream Deck event for one key, with the named settings.
+type keyEvent struct {
+	event    string
+	key      string
+	settings string
+}
+
+func TestKeyPollers(t *testing.T) {
+	tests := []struct {
+		name string
+		// interval is the service's refresh interval.
+		// An hour means each poller fetches only once, when it starts.
+		interval time.Duration
+		events   []keyEvent
+		check    func(t *testing.T, svc *fakeService, h *handlers[fakeSettings, int])
+	}{
+		{
+			name:     "two keys on one action poll independently",
+			interval: 10 * time.Millisecond,
+			events: []keyEvent{
+				{streamdeck.WillAppear, "key-a", "a"},
+				{streamdeck.WillAppear, "key-b", "b"},
+			},
+			check: func(t *testing.T, svc *fakeService, h *handlers[fakeSettings, int]) {
+				eventually(t, "both keys should keep polling", func() bool {
+					return svc.fetched("a") >= 3 && svc.fetched("b") >= 3
+				})
+			},
+		},
+		{
+			name:     "the other key keeps polling after one disappears",
+			interval: 10 * time.Millisecond,
+			events: []keyEvent{
+				{streamdeck.WillAppear, "key-a", "a"},
+				{streamdeck.WillAppear, "key-b", "b"},
+				{streamdeck.WillDisappear, "key-a", "a"},
+			},
+			check: func(t *testing.T, svc *fakeService, h *handlers[fakeSettings, int]) {
+				stoppedAt := svc.fetched("a")
+				polledB := svc.fetched("b")
+				eventually(t, "key-b should keep polling", func() bool {
+					return svc.fetched("b") >= polledB+3
+				})
+				if got := svc.fetched("a"); got != stoppedAt {
+					t.Errorf("key-a fetched %d times after disappearing", got-stoppedAt)
+				}
+				if _, ok := h.store.Get("key-a"); ok {
+					t.Error("key-a is still in the store")
+				}
+			},
+		},
+		{
+			name:     "a second WillDisappear for the same key is harmless",
+			interval: 10 * time.Millisecond,
+			events: []keyEvent{
+				{streamdeck.WillAppear, "key-a", "a"},
+				{streamdeck.WillDisappear, "key-a", "a"},
+				{streamdeck.WillDisappear, "key-a", "a"},
+			},
+			check: func(t *testing.T, svc *fakeService, h *handlers[fakeSettings, int]) {
+				if h.scheduler.Stop("key-a") {
+					t.Error("key-a still has a poller")
+				}
+			},
+		},
+		{
+			name:     "settings that arrive after the key went away are ignored",
+			interval: 10 * time.Millisecond,
+			events: []keyEvent{
+				{streamdeck.WillAppear, "key-a", "a"},
+				{streamdeck.WillDisappear, "key-a", "a"},
+				{streamdeck.DidReceiveSettings, "key-a", "a2"},
+			},
+			check: func(t *testing.T, svc *fakeService, h *handlers[fakeSettings, int]) {
+				// Give an unwanted poller time to show up
+				time.Sleep(50 * time.Millisecond)
+				if got := svc.fetched("a2"); got != 0 {
+					t.Errorf("fetched %d times with the late settings", got)
+				}
+				if _, ok := h.store.Get("key-a"); ok {
+					t.Error("the late settings brought key-a back")
+				}
+				if h.scheduler.Stop("key-a") {
+					t.Error("the late settings started a poller")
+				}
+			},
+		},
+		{
+			name:     "a settings change restarts only that key's poller",
+			interval: time.Hour,
+			events: []keyEvent{
+				{streamdeck.WillAppear, "key-a", "a"},
+				{streamdeck.WillAppear, "key-b", "b"},
+				{streamdeck.DidReceiveSettings, "key-a", "a2"},
+			},
+			check: func(t *testing.T, svc *fakeService, h *handlers[fakeSettings, int]) {
+				eventually(t, "key-a should fetch with its new settings", func() bool {
+					return svc.fetched("a2") == 1
+				})
+				// Give an unwanted restart of key-b time to show up
+				time.Sleep(50 * time.Millisecond)
+				if got := svc.fetched("b"); got != 1 {
+					t.Errorf("key-b fetched %d times, want 1", got)
+				}
+				state, _ := h.store.Get("key-a")
+				if state.Settings.Name != "a2" {
+					t.Errorf("key-a has settings %q, want a2", state.Settings.Name)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeStreamDeck(t)
+			svc := newFakeService(tt.interval)
+			h := newHandlers(svc)
+			ctx, cancel := context.WithCancel(t.Context())
+			defer cancel()
+
+			for _, e := range tt.events {
+				event := settingsEvent(t, e.event, e.key, e.settings)
+				var err error
+				switch e.event {
+				case streamdeck.WillAppear:
+					err = h.willAppear(ctx, client, event)
+					// Let the first fetch happen before the next event
+					eventually(t, e.key+" should fetch when it appears", func() bool {
+						return svc.fetched(e.settings) > 0
+					})
+				case streamdeck.WillDisappear:
+					err = h.willDisappear(ctx, client, event)
+				case streamdeck.DidReceiveSettings:
+					err = h.didReceiveSettings(ctx, client, event)
+				}
+				if err != nil {
+					t.Fatalf("%s for %s: %v", e.event, e.key, err)
+				}
+			}
+
+			tt.check(t, svc, h)
+		})
+	}
+}
+
+func TestSchedulerStopUnknownKey(t *testing.T) {
+	scheduler := NewScheduler()
+	if scheduler.Stop("missing") {
+		t.Error("stopping a key that never started reported a poller")
+	}
+}
+
+func TestSchedulerRefusesMissingKeys(t *testing.T) {
+	scheduler := NewScheduler()
+	polled := make(chan struct{}, 1)
+	poll := func(ctx context.Context) (time.Duration, error) {
+		polled <- struct{}{}
+
+		return 0, errKeyGone
+	}
+
+	if scheduler.Start(t.Context(), "missing", time.Millisecond, poll, func() bool { return false }) {
+		t.Error("started a poller for a key that does not exist")
+	}
+	if scheduler.Stop("missing") {
+		t.Error("the missing key has a poller")
+	}
+
+	if !scheduler.Start(t.Context(), "gone", time.Millisecond, poll, func() bool { return true }) {
+		t.Fatal("the poller did not start")
+	}
+	<-polled
+	// The key went away, so the poller must not poll again
+	select {
+	case <-polled:
+		t.Error("polled again after the key was gone")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// fakeRunner is a fakeService whose short press runs an action
+// that blocks until release is closed.
+type fakeRunner struct {
+	*fakeService
+
+	started chan struct{}
+	release chan struct{}
+}
+
+var _ GestureHandler[fakeSettings, int] = (*fakeRunner)(nil)
+
+func (f *fakeRunner) HandleGesture(gesture Gesture, settings fakeSettings, result int) KeyAction {
+	if gesture != GesturePress {
+		return KeyAction{Kind: KeyActionNone}
+	}
+
+	return KeyAction{Kind: KeyActionRun, Run: func(ctx context.Context) error {
+		close(f.started)
+		<-f.release
+
+		return nil
+	}}
+}
+
+func TestDisappearDuringRun(t *testing.T) {
+	client := newFakeStreamDeck(t)
+	svc := &fakeRunner{
+		fakeService: newFakeService(10 * time.Millisecond),
+		started:     make(chan struct{}),
+		release:     make(chan struct{}),
+	}
+	h := newHandlers[fakeSettings, int](svc)
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	for _, name := range []string{streamdeck.WillAppear, streamdeck.KeyDown, streamdeck.KeyUp} {
+		event := settingsEvent(t, name, "key-a", "a")
+		var err error
+		switch name {
+		case streamdeck.WillAppear:
+			err = h.willAppear(ctx, client, event)
+		case streamdeck.KeyDown:
+			err = h.keyDown(ctx, client, event)
+		case streamdeck.KeyUp:
+			err = h.keyUp(ctx, client, event)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+
+	select {
+	case <-svc.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the key action never ran")
+	}
+	if err := h.willDisappear(ctx, client, settingsEvent(t, streamdeck.WillDisappear, "key-a", "a")); err != nil {
+		t.Fatal(err)
+	}
+	stoppedAt := svc.fetched("a")
+
+	// The action finishes after the key has gone, and refreshes it
+	close(svc.release)
+	time.Sleep(100 * time.Millisecond)
+
+	if got := svc.fetched("a"); got != stoppedAt {
+		t.Errorf("key-a fetched %d times after disappearing", got-stoppedAt)
+	}
+	if h.scheduler.Stop("key-a") {
+		t.Error("the key action started a poller for a key that is gone")
+	}
+}
@@ -16,7 +16,8 @@ type Service[S any, R any] interface {
 	// ActionUUID returns the Stream Deck action identifier
 	ActionUUID() string
 
-	// RefreshInterval returns how often to poll for updates
+	// RefreshInterval returns how often to poll for updates,
+	// unless the key's CommonSettings choose another interval
 	RefreshInterval() time.Duration
 
 	// ParseSettings unmarshals JSON settings into the service's settings type
@@ -25,7 +26,9 @@ type Service[S any, R any] interface {
 	// FetchResult fetches the current inbox state
 	FetchResult(ctx context.Context, settings S) (R, error)
 
-	// Render updates the Stream Deck button display
+	// Render updates the Stream Deck button display.
+	// If err is a *StaleError, result is the last good result and should
+	// still be shown, marked as stale (see IsStale).
 	Render(ctx context.Context, client *streamdeck.Client, result R, err error) error
 
 	// OpenURL returns the URL to open when the button is pressed
@@ -40,6 +43,8 @@ type Service[S any, R any] interface {
 type SendToPluginHandler[S any] interface {
 	// HandleSendToPlugin processes messages from the property inspector.
 	// Returns a response payload to send back, or nil if no response needed.
+	// The context expires after FetchTimeout; work that outlives the call
+	// must not use it.
 	HandleSendToPlugin(ctx context.Context, client *streamdeck.Client,
 		payload json.RawMessage, settings S) (interface{}, error)
 }
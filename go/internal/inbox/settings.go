@@ -0,0 +1,133 @@
+package inbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMinRefreshInterval is the shortest refresh interval a key may ask for,
+// for services that do not implement MinRefreshIntervalProvider.
+const DefaultMinRefreshInterval = 15 * time.Second
+
+// MinRefreshIntervalProvider is an optional interface for services whose
+// API quotas need a longer minimum refresh interval than the default.
+type MinRefreshIntervalProvider interface {
+	MinRefreshInterval() time.Duration
+}
+
+// CommonSettings are the settings shared by every action.
+// They are parsed from the same JSON as the service's own settings.
+type CommonSettings struct {
+	// RefreshSeconds is how often to poll. Zero means the service's default.
+	RefreshSeconds Seconds `json:"refreshSeconds"`
+	// PressAction is what a short press does. Empty means PressActionOpen.
+	PressAction PressAction `json:"pressAction"`
+	// StaleFailures is how many consecutive failed fetches the key rides out,
+	// showing its last good result. Zero means DefaultMaxFailures.
+	StaleFailures Count `json:"staleFailures"`
+	// StaleSeconds is how old that result may get. Zero means DefaultMaxStaleAge.
+	StaleSeconds Seconds `json:"staleSeconds"`
+}
+
+// PressAction is what a short press on a key does.
+type PressAction string
+
+const (
+	// PressActionOpen opens the service's URL, then refreshes.
+	PressActionOpen PressAction = "open"
+	// PressActionRefresh only refreshes.
+	PressActionRefresh PressAction = "refresh"
+)
+
+// ParseCommonSettings unmarshals the shared settings from a key's settings JSON.
+func ParseCommonSettings(raw json.RawMessage) (CommonSettings, error) {
+	var common CommonSettings
+	if len(raw) == 0 {
+		return common, nil
+	}
+	if err := json.Unmarshal(raw, &common); err != nil {
+		return CommonSettings{}, err
+	}
+
+	return common, nil
+}
+
+// RefreshInterval returns the key's refresh interval:
+// the service's default unless the key chose one, and never below minimum.
+func (c CommonSettings) RefreshInterval(defaultInterval, minimum time.Duration) time.Duration {
+	if c.RefreshSeconds <= 0 {
+		return defaultInterval
+	}
+
+	return max(time.Duration(c.RefreshSeconds)*time.Second, minimum)
+}
+
+// StalePolicy returns the key's StalePolicy:
+// DefaultStalePolicy, with any limits the key chose instead.
+func (c CommonSettings) StalePolicy() StalePolicy {
+	policy := DefaultStalePolicy()
+	if c.StaleFailures > 0 {
+		policy.MaxFailures = int(c.StaleFailures)
+	}
+	if c.StaleSeconds > 0 {
+		policy.MaxAge = time.Duration(c.StaleSeconds) * time.Second
+	}
+
+	return policy
+}
+
+// Seconds is a number of seconds that also accepts a numeric string,
+// because the property inspector sends form values as strings.
+type Seconds int
+
+func (s *Seconds) UnmarshalJSON(data []byte) error {
+	number, err := unmarshalNumber(data, "seconds")
+	if err != nil {
+		return err
+	}
+	*s = Seconds(number)
+
+	return nil
+}
+
+// Count is a number of times that, like Seconds, also accepts a numeric string.
+type Count int
+
+func (c *Count) UnmarshalJSON(data []byte) error {
+	number, err := unmarshalNumber(data, "times")
+	if err != nil {
+		return err
+	}
+	*c = Count(number)
+
+	return nil
+}
+
+// unmarshalNumber accepts a JSON number, or a string holding one.
+// An empty string is zero. The unit names the number in errors.
+func unmarshalNumber(data []byte, unit string) (int, error) {
+	var number int
+	if err := json.Unmarshal(data, &number); err == nil {
+		return number, nil
+	}
+
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return 0, fmt.Errorf("%s must be a number or a string: %w", unit, err)
+	}
+
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return 0, nil
+	}
+
+	number, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number of %s %q: %w", unit, str, err)
+	}
+
+	return number, nil
+}
@@ -0,0 +1,100 @@
+package inbox
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultMaxFailures is how many consecutive transient failures a button
+	// rides out, showing its last good result, before it shows the error.
+	DefaultMaxFailures = 3
+
+	// DefaultMaxStaleAge is how old the last good result may get
+	// before the button shows the error instead.
+	DefaultMaxStaleAge = 15 * time.Minute
+)
+
+// StalePolicy decides how long a button keeps showing its last good result
+// while fetches are failing.
+type StalePolicy struct {
+	// MaxFailures is the number of consecutive failures tolerated.
+	MaxFailures int
+	// MaxAge is the maximum age of the result being shown.
+	MaxAge time.Duration
+}
+
+// DefaultStalePolicy returns the policy of keys that do not choose
+// their own limits (see CommonSettings.StalePolicy).
+func DefaultStalePolicy() StalePolicy {
+	return StalePolicy{MaxFailures: DefaultMaxFailures, MaxAge: DefaultMaxStaleAge}
+}
+
+// allows reports whether a result fetched at lastGoodAt may still be shown
+// after the given number of consecutive failures.
+func (p StalePolicy) allows(failures int, lastGoodAt, now time.Time) bool {
+	return failures <= p.MaxFailures && now.Sub(lastGoodAt) <= p.MaxAge
+}
+
+// StaleError is passed to Service.Render in place of a transient fetch error
+// while the button keeps showing its last good result.
+// The result passed alongside it is that last good result.
+type StaleError struct {
+	Err      error
+	Since    time.Time
+	Failures int
+}
+
+func (e *StaleError) Error() string {
+	return fmt.Sprintf(
+		"showing result from %s after %d failure(s): %v",
+		e.Since.Format(time.Kitchen),
+		e.Failures,
+		e.Err,
+	)
+}
+
+func (e *StaleError) Unwrap() error {
+	return e.Err
+}
+
+// IsStale reports whether err means "render the result, but mark it as stale".
+func IsStale(err error) bool {
+	var staleErr *StaleError
+
+	return errors.As(err, &staleErr)
+}
+
+// isTransient reports whether an error is likely to go away on its own.
+// Configuration and credential problems are never waited out.
+func isTransient(err error) bool {
+	switch Categorize(err) {
+	case CategoryNetwork, CategoryRateLimit, CategoryUpstream:
+		return true
+	case CategoryUnknown, CategoryConfig, CategoryAuth:
+		return false
+	default:
+		return false
+	}
+}
+
+// present chooses what a button should render for its current state:
+// the fresh result, the last good result marked as stale, or the error.
+func present[S any, R any](state ButtonState[S, R], policy StalePolicy, now time.Time) (R, error) {
+	if state.Err == nil {
+		return state.Result, nil
+	}
+
+	if !state.LastGoodAt.IsZero() &&
+		isTransient(state.Err) &&
+		policy.allows(state.Failures, state.LastGoodAt, now) {
+		return state.LastGood, &StaleError{
+			Err:      state.Err,
+			Since:    state.LastGoodAt,
+			Failures: state.Failures,
+		}
+	}
+
+	return state.Result, state.Err
+}
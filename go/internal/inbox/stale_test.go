@@ -0,0 +1,177 @@
+package inbox
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"ca.michaelabon.inboxes/internal/display"
+)
+
+func TestPresent(t *testing.T) {
+	now := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+	offline := WrapError(CategoryNetwork, errors.New("offline"))
+	badToken := WrapError(CategoryAuth, errors.New("bad token"))
+
+	tests := []struct {
+		name   string
+		state  ButtonState[fakeSettings, uint]
+		common CommonSettings
+		// wantTitle and wantState are what RenderCount shows.
+		wantTitle string
+		wantState int
+		wantStale bool
+	}{
+		{
+			name:      "a fresh result",
+			state:     ButtonState[fakeSettings, uint]{Result: 5, LastGood: 5, LastGoodAt: now},
+			wantTitle: display.PadRight("5"),
+			wantState: DefaultState,
+		},
+		{
+			name: "under the failure threshold shows the last value",
+			state: ButtonState[fakeSettings, uint]{
+				Err: offline, Failures: DefaultMaxFailures,
+				LastGood: 3, LastGoodAt: now.Add(-time.Minute),
+			},
+			wantTitle: display.PadRight("3" + StaleMarker),
+			wantState: DefaultState,
+			wantStale: true,
+		},
+		{
+			name: "an empty inbox stays gold while stale",
+			state: ButtonState[fakeSettings, uint]{
+				Err: offline, Failures: 1,
+				LastGood: 0, LastGoodAt: now.Add(-time.Minute),
+			},
+			wantTitle: display.PadRight(StaleMarker),
+			wantState: GoldState,
+			wantStale: true,
+		},
+		{
+			name: "over the failure threshold shows the error glyph",
+			state: ButtonState[fakeSettings, uint]{
+				Err: offline, Failures: DefaultMaxFailures + 1,
+				LastGood: 3, LastGoodAt: now.Add(-time.Minute),
+			},
+			wantTitle: display.PadRight("net"),
+			wantState: DefaultState,
+		},
+		{
+			name: "over the maximum age shows the error glyph",
+			state: ButtonState[fakeSettings, uint]{
+				Err: offline, Failures: 1,
+				LastGood: 3, LastGoodAt: now.Add(-DefaultMaxStaleAge - time.Second),
+			},
+			wantTitle: display.PadRight("net"),
+			wantState: DefaultState,
+		},
+		{
+			name: "the key can tolerate more failures",
+			state: ButtonState[fakeSettings, uint]{
+				Err: offline, Failures: 8,
+				LastGood: 3, LastGoodAt: now.Add(-time.Minute),
+			},
+			common:    CommonSettings{StaleFailures: 10},
+			wantTitle: display.PadRight("3" + StaleMarker),
+			wantState: DefaultState,
+			wantStale: true,
+		},
+		{
+			name: "the key can allow a shorter age",
+			state: ButtonState[fakeSettings, uint]{
+				Err: offline, Failures: 1,
+				LastGood: 3, LastGoodAt: now.Add(-2 * time.Minute),
+			},
+			common:    CommonSettings{StaleSeconds: 60},
+			wantTitle: display.PadRight("net"),
+			wantState: DefaultState,
+		},
+		{
+			name: "a rejected token is never waited out",
+			state: ButtonState[fakeSettings, uint]{
+				Err: badToken, Failures: 1,
+				LastGood: 3, LastGoodAt: now.Add(-time.Minute),
+			},
+			wantTitle: display.PadRight("auth"),
+			wantState: DefaultState,
+		},
+		{
+			name:      "nothing to fall back on shows the error glyph",
+			state:     ButtonState[fakeSettings, uint]{Err: offline, Failures: 1},
+			wantTitle: display.PadRight("net"),
+			wantState: DefaultState,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shown, err := present(tt.state, tt.common.StalePolicy(), now)
+
+			if IsStale(err) != tt.wantStale {
+				t.Fatalf("IsStale(%v) = %v, want %v", err, !tt.wantStale, tt.wantStale)
+			}
+
+			// As RenderCount and RenderError show it
+			var title string
+			var state int
+			if err != nil && !IsStale(err) {
+				title, state = display.PadRight(Categorize(err).Glyph()), DefaultState
+			} else {
+				title, state = countDisplay(shown, IsStale(err))
+			}
+			if title != tt.wantTitle {
+				t.Errorf("title = %q, want %q", title, tt.wantTitle)
+			}
+			if state != tt.wantState {
+				t.Errorf("state = %d, want %d", state, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestStaleError(t *testing.T) {
+	offline := WrapError(CategoryNetwork, errors.New("offline"))
+	err := error(&StaleError{Err: offline, Since: time.Now(), Failures: 2})
+
+	if !errors.Is(err, offline) {
+		t.Error("a StaleError does not unwrap to its fetch error")
+	}
+	if got := Categorize(err); got != CategoryNetwork {
+		t.Errorf("category = %v, want network", got)
+	}
+	if IsStale(offline) {
+		t.Error("a plain fetch error is stale")
+	}
+}
+
+func TestCommonStalePolicy(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want StalePolicy
+	}{
+		{raw: `{}`, want: DefaultStalePolicy()},
+		{raw: `{"staleFailures":"","staleSeconds":""}`, want: DefaultStalePolicy()},
+		{
+			raw:  `{"staleFailures":"5","staleSeconds":"3600"}`,
+			want: StalePolicy{MaxFailures: 5, MaxAge: time.Hour},
+		},
+		{
+			raw:  `{"staleFailures":1}`,
+			want: StalePolicy{MaxFailures: 1, MaxAge: DefaultMaxStaleAge},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			common, err := ParseCommonSettings(json.RawMessage(tt.raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := common.StalePolicy(); got != tt.want {
+				t.Errorf("StalePolicy() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
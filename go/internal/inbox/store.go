@@ -0,0 +1,128 @@
+package inbox
+
+import (
+	"sync"
+	"time"
+)
+
+// ButtonState is a snapshot of everything known about a single button.
+type ButtonState[S any, R any] struct {
+	Settings  S
+	Common    CommonSettings
+	Result    R
+	Err       error
+	FetchedAt time.Time
+
+	// LastGood is the most recent successful result, kept while fetches fail.
+	LastGood   R
+	LastGoodAt time.Time
+	// Failures counts consecutive failed fetches.
+	Failures int
+}
+
+// Current returns the result to act on when the button is pressed:
+// the latest result, or the last good one if the latest fetch failed.
+func (s ButtonState[S, R]) Current() R {
+	if s.Err != nil && !s.LastGoodAt.IsZero() {
+		return s.LastGood
+	}
+
+	return s.Result
+}
+
+// Store holds the state of every visible button of one action, keyed by
+// the Stream Deck context. It is safe for concurrent use by the event
+// handlers and the pollers.
+type Store[S any, R any] struct {
+	mu     sync.RWMutex
+	states map[string]*ButtonState[S, R]
+}
+
+// NewStore returns an empty Store.
+func NewStore[S any, R any]() *Store[S, R] {
+	return &Store[S, R]{states: map[string]*ButtonState[S, R]{}}
+}
+
+// Get returns a copy of the state for key.
+func (s *Store[S, R]) Get(key string) (ButtonState[S, R], bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state, ok := s.states[key]
+	if !ok {
+		return ButtonState[S, R]{}, false
+	}
+
+	return *state, true
+}
+
+// Add stores the settings of a key that has just appeared,
+// creating its entry. It is the only way an entry is created.
+// Any previous result is kept so the button can still be opened.
+func (s *Store[S, R]) Add(key string, settings S, common CommonSettings) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.states[key]
+	if !ok {
+		state = &ButtonState[S, R]{}
+		s.states[key] = state
+	}
+	state.Settings = settings
+	state.Common = common
+}
+
+// SetSettings stores new settings for key, keeping any previous result.
+// It reports false, and stores nothing, if the key has since been deleted,
+// so late settings cannot bring back a key that went away.
+func (s *Store[S, R]) SetSettings(key string, settings S, common CommonSettings) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.states[key]
+	if !ok {
+		return false
+	}
+	state.Settings = settings
+	state.Common = common
+
+	return true
+}
+
+// SetResult records the outcome of a fetch for key and returns the updated state.
+// It reports false, and stores nothing, if the key has since been deleted.
+func (s *Store[S, R]) SetResult(
+	key string,
+	result R,
+	err error,
+	fetchedAt time.Time,
+) (ButtonState[S, R], bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.states[key]
+	if !ok {
+		return ButtonState[S, R]{}, false
+	}
+	state.Result = result
+	state.Err = err
+	state.FetchedAt = fetchedAt
+
+	if err == nil {
+		state.LastGood = result
+		state.LastGoodAt = fetchedAt
+		state.Failures = 0
+	} else {
+		state.Failures++
+	}
+
+	return *state, true
+}
+
+// Delete forgets everything about key.
+func (s *Store[S, R]) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.states, key)
+}
@@ -0,0 +1,151 @@
+package inbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/samwho/streamdeck"
+)
+
+// TestHandlersConcurrently drives every handler of one action from many
+// goroutines at once, as the Stream Deck and the pollers do.
+// Run it with -race.
+func TestHandlersConcurrently(t *testing.T) {
+	client := newFakeStreamDeck(t)
+	svc := newFakeService(time.Millisecond)
+	h := newHandlers(svc)
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	const keys = 8
+	const rounds = 20
+
+	var wg sync.WaitGroup
+	for k := range keys {
+		key := fmt.Sprintf("key-%d", k)
+		for worker := range 4 {
+			wg.Go(func() {
+				for round := range rounds {
+					settings := fmt.Sprintf("%s-%d", key, (worker+round)%3)
+					var err error
+					switch (worker + round) % 5 {
+					case 0:
+						err = h.willAppear(ctx, client, settingsEvent(t, streamdeck.WillAppear, key, settings))
+					case 1:
+						err = h.keyDown(ctx, client, settingsEvent(t, streamdeck.KeyDown, key, settings))
+						if err == nil {
+							err = h.keyUp(ctx, client, settingsEvent(t, streamdeck.KeyUp, key, settings))
+						}
+					case 2:
+						err = h.didReceiveSettings(
+							ctx,
+							client,
+							settingsEvent(t, streamdeck.DidReceiveSettings, key, settings),
+						)
+					case 3:
+						err = h.willDisappear(ctx, client, settingsEvent(t, streamdeck.WillDisappear, key, settings))
+					case 4:
+						if state, ok := h.store.Get(key); ok {
+							_ = state.Current()
+						}
+					}
+					if err != nil {
+						t.Errorf("%s round %d: %v", key, round, err)
+					}
+				}
+			})
+		}
+	}
+	wg.Wait()
+
+	// Every key ends up gone, whatever order the events arrived in
+	for k := range keys {
+		key := fmt.Sprintf("key-%d", k)
+		if err := h.willDisappear(ctx, client, settingsEvent(t, streamdeck.WillDisappear, key, "")); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := h.store.Get(key); ok {
+			t.Errorf("%s is still in the store", key)
+		}
+	}
+}
+
+func TestStoreConcurrently(t *testing.T) {
+	store := NewStore[fakeSettings, int]()
+	failed := errors.New("failed")
+
+	var wg sync.WaitGroup
+	for worker := range 8 {
+		wg.Go(func() {
+			key := fmt.Sprintf("key-%d", worker%2)
+			for round := range 100 {
+				switch round % 4 {
+				case 0:
+					store.Add(key, fakeSettings{Name: key}, CommonSettings{})
+				case 1:
+					store.SetResult(key, round, nil, time.Now())
+				case 2:
+					store.SetResult(key, 0, failed, time.Now())
+				case 3:
+					if round%20 == 3 {
+						store.Delete(key)
+					} else {
+						_, _ = store.Get(key)
+					}
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+func TestStoreKeepsLastGoodResult(t *testing.T) {
+	store := NewStore[fakeSettings, int]()
+	now := time.Now()
+
+	if _, ok := store.SetResult("key", 1, nil, now); ok {
+		t.Fatal("a result was stored for a key without settings")
+	}
+
+	store.Add("key", fakeSettings{Name: "key"}, CommonSettings{})
+	store.SetResult("key", 5, nil, now)
+	state, ok := store.SetResult("key", 0, errors.New("failed"), now.Add(time.Minute))
+	if !ok {
+		t.Fatal("the result was not stored")
+	}
+
+	if state.Failures != 1 {
+		t.Errorf("Failures = %d, want 1", state.Failures)
+	}
+	if got := state.Current(); got != 5 {
+		t.Errorf("Current() = %d, want the last good result 5", got)
+	}
+}
+
+func TestStoreSetAfterDelete(t *testing.T) {
+	store := NewStore[fakeSettings, int]()
+
+	if store.SetSettings("key", fakeSettings{Name: "new"}, CommonSettings{}) {
+		t.Error("settings were stored for a key that never appeared")
+	}
+
+	store.Add("key", fakeSettings{Name: "key"}, CommonSettings{})
+	if !store.SetSettings("key", fakeSettings{Name: "changed"}, CommonSettings{}) {
+		t.Error("the settings of a visible key were not stored")
+	}
+
+	store.Delete("key")
+	if store.SetSettings("key", fakeSettings{Name: "late"}, CommonSettings{}) {
+		t.Error("late settings were stored for a deleted key")
+	}
+	if _, ok := store.SetResult("key", 1, nil, time.Now()); ok {
+		t.Error("a late result was stored for a deleted key")
+	}
+	if _, ok := store.Get("key"); ok {
+		t.Error("the deleted key came back")
+	}
+}
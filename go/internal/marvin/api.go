@@ -1,16 +1,16 @@
 package marvin
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io"
-	"log"
 	"net/http"
 	"net/url"
 	"time"
 
+	"ca.michaelabon.inboxes/internal/httpx"
+	"ca.michaelabon.inboxes/internal/inbox"
 	"golang.org/x/exp/slices"
 )
 
@@ -23,21 +23,21 @@ type Settings struct {
 
 const RefreshInterval = time.Minute
 
-func FetchUnseenCount(settings *Settings) (uint, error) {
+func FetchUnseenCount(ctx context.Context, client *httpx.Client, settings *Settings) (uint, error) {
 	if settings.Server == "" {
-		return 0, errors.New("missing Server")
+		return 0, inbox.NewError(inbox.CategoryConfig, "missing Server")
 	}
 	if settings.Database == "" {
-		return 0, errors.New("missing Database")
+		return 0, inbox.NewError(inbox.CategoryConfig, "missing Database")
 	}
 	if settings.User == "" {
-		return 0, errors.New("missing User")
+		return 0, inbox.NewError(inbox.CategoryConfig, "missing User")
 	}
 	if settings.Password == "" {
-		return 0, errors.New("missing Password")
+		return 0, inbox.NewError(inbox.CategoryConfig, "missing Password")
 	}
 
-	return getUnseenCount(settings)
+	return getUnseenCount(ctx, client, settings)
 }
 
 type response struct {
@@ -96,44 +96,27 @@ type mixedObject struct {
 	return err
 }
 
-func getUnseenCount(settings *Settings) (uint, error) {
-	client := &http.Client{}
-
+func getUnseenCount(ctx context.Context, client *httpx.Client, settings *Settings) (uint, error) {
 	marvinUrl, err := url.Parse(settings.Server)
 	if err != nil {
-		return 0, fmt.Errorf("error while parsing url: %w", err)
+		return 0, inbox.WrapError(inbox.CategoryConfig, fmt.Errorf("error while parsing url: %w", err))
 	}
 	marvinUrl = marvinUrl.JoinPath(settings.Database, "_all_docs")
 	query := marvinUrl.Query()
 	query.Add("include_docs", "true")
 	marvinUrl.RawQuery = query.Encode()
 
-	req, err := http.NewRequest("GET", marvinUrl.String(), nil)
-	if err != nil {
-		return 0, fmt.Errorf("error while newing request: %w", err)
-	}
-	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Authorization", makeBasicAuthorization(settings))
-
-	res, err := client.Do(req)
-	if err != nil {
-		return 0, fmt.Errorf("error while doing request: %w", err)
-	}
-
-	defer func(body io.ReadCloser) {
-		err := body.Close()
-		if err != nil {
-			log.Println("[marvin]", "error while closing body", err)
-		}
-	}(res.Body)
+	header := http.Header{}
+	header.Set("Accept", "application/json")
+	header.Set("Authorization", makeBasicAuthorization(settings))
 
-	resBody, err := io.ReadAll(res.Body)
+	res, err := client.Get(ctx, marvinUrl.String(), header)
 	if err != nil {
-		return 0, fmt.Errorf("error while reading body: %w", err)
+		return 0, fmt.Errorf("error while getting tasks: %w", err)
 	}
 
 	marvinResponse := &response{}
-	err = json.Unmarshal(resBody, marvinResponse)
+	err = json.Unmarshal(res.Body, marvinResponse)
 	if err != nil {
 		return 0, fmt.Errorf("error while unmarshalling session response: %w", err)
 	}
@@ -5,12 +5,20 @@
 	"encoding/json"
 	"time"
 
+	"ca.michaelabon.inboxes/internal/httpx"
 	"ca.michaelabon.inboxes/internal/inbox"
 	"github.com/samwho/streamdeck"
 )
 
 // Service implements inbox.Service for Amazing Marvin.
-type Service struct{}
+// Use NewService, which sets up its HTTP client.
+type Service struct {
+	client *httpx.Client
+}
+
+func NewService() Service {
+	return Service{client: httpx.New("[marvin]")}
+}
 
 // Compile-time check that Service implements the interface.
 var _ inbox.Service[*Settings, uint] = Service{}
@@ -37,7 +45,7 @@ func (s Service) ParseSettings(raw json.RawMessage) (*Settings, error) {
 }
 
 func (s Service) FetchResult(ctx context.Context, settings *Settings) (uint, error) {
-	return FetchUnseenCount(settings)
+	return FetchUnseenCount(ctx, s.client, settings)
 }
 
 func (s Service) Render(
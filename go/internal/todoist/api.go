@@ -1,13 +1,14 @@
 package todoist
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io"
-	"log"
 	"net/http"
 	"time"
+
+	"ca.michaelabon.inboxes/internal/httpx"
+	"ca.michaelabon.inboxes/internal/inbox"
 )
 
 type Settings struct {
@@ -16,12 +17,12 @@ type Settings struct {
 
 const RefreshInterval = time.Minute
 
-func FetchUnseenCount(settings *Settings) (uint, error) {
+func FetchUnseenCount(ctx context.Context, client *httpx.Client, settings *Settings) (uint, error) {
 	if settings.ApiToken == "" {
-		return 0, errors.New("missing ApiToken")
+		return 0, inbox.NewError(inbox.CategoryConfig, "missing ApiToken")
 	}
 
-	return getUnseenCount(settings)
+	return getUnseenCount(ctx, client, settings)
 }
 
 type project struct {
@@ -34,37 +35,20 @@ type task struct {
 	IsCompleted bool `json:"is_completed"`
 }
 
-func getUnseenCount(settings *Settings) (uint, error) {
-	client := &http.Client{}
+func getUnseenCount(ctx context.Context, client *httpx.Client, settings *Settings) (uint, error) {
+	header := http.Header{}
+	header.Set("Accept", "application/json")
+	header.Set("Authorization", "Bearer "+settings.ApiToken)
 
 	projectsUrl := "https://api.todoist.com/rest/v2/projects"
 
-	projectsRequest, err := http.NewRequest("GET", projectsUrl, nil)
-	if err != nil {
-		return 0, fmt.Errorf("error while newing projects request: %w", err)
-	}
-	projectsRequest.Header.Add("Accept", "application/json")
-	projectsRequest.Header.Add("Authorization", "Bearer "+settings.ApiToken)
-
-	projectsResponse, err := client.Do(projectsRequest)
+	projectsResponse, err := client.Get(ctx, projectsUrl, header)
 	if err != nil {
 		return 0, fmt.Errorf("error while doing projects request: %w", err)
 	}
 
-	defer func(body io.ReadCloser) {
-		err := body.Close()
-		if err != nil {
-			log.Println("[todoist]", "error while closing body", err)
-		}
-	}(projectsResponse.Body)
-
-	projectsResponseBody, err := io.ReadAll(projectsResponse.Body)
-	if err != nil {
-		return 0, fmt.Errorf("error while reading projects response body: %w", err)
-	}
-
 	var projects []project
-	err = json.Unmarshal(projectsResponseBody, &projects)
+	err = json.Unmarshal(projectsResponse.Body, &projects)
 	if err != nil {
 		return 0, fmt.Errorf(
 			"error while unmarshalling projects response: %w",
@@ -83,36 +67,14 @@ func getUnseenCount(settings *Settings) (uint, error) {
 	for _, inboxProjectID := range inboxProjectIDs {
 		tasksUrl := "https://api.todoist.com/rest/v2/tasks?project_id=" + inboxProjectID
 
-		tasksRequest, err := http.NewRequest("GET", tasksUrl, nil)
-		if err != nil {
-			return 0, fmt.Errorf("error while calling NewRequest to GET tasks: %w", err)
-		}
-		tasksRequest.Header.Add("Accept", "application/json")
-		tasksRequest.Header.Add("Authorization", "Bearer "+settings.ApiToken)
-
-		tasksResponse, err := client.Do(tasksRequest)
+		tasksResponse, err := client.Get(ctx, tasksUrl, header)
 		if err != nil {
 			return 0, fmt.Errorf("error while doing GET tasks request: %w", err)
 		}
 
-		tasksResponseBody, err := io.ReadAll(tasksResponse.Body)
-		if err != nil {
-			closeErr := tasksResponse.Body.Close()
-			if closeErr != nil {
-				err = fmt.Errorf("error while closing task response body: %w", err)
-			}
-
-			return 0, fmt.Errorf("error while reading tasks body: %w", err)
-		}
-
 		var tasks []task
-		err = json.Unmarshal(tasksResponseBody, &tasks)
+		err = json.Unmarshal(tasksResponse.Body, &tasks)
 		if err != nil {
-			closeErr := tasksResponse.Body.Close()
-			if closeErr != nil {
-				err = fmt.Errorf("error while closing task response body: %w", err)
-			}
-
 			return 0, fmt.Errorf(
 				"error while unmarshalling tasks response: %w",
 				err,
@@ -120,12 +82,7 @@ func getUnseenCount(settings *Settings) (uint, error) {
 		}
 
 		totalTasks += uint(len(tasks))
-
-		closeErr := tasksResponse.Body.Close()
-		if closeErr != nil {
-			return 0, fmt.Errorf("error while closing task response body: %w", err)
-		}
 	}
 
-	return totalTasks, err
+	return totalTasks, nil
 }
@@ -1,46 +1,105 @@
 package ynab
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"ca.michaelabon.inboxes/internal/httpx"
+	"ca.michaelabon.inboxes/internal/inbox"
 	"golang.org/x/exp/slices"
 )
 
 type Settings struct {
 	BudgetUuid          string `json:"budgetUuid"`
 	PersonalAccessToken string `json:"apiToken"`
-	NextAccountId       string `json:"-"`
 }
 
 const FastRefreshInterval = 20 * time.Second
 
-func FetchUnseenCountAndNextAccountId(settings *Settings) (uint, error) {
+// MinRefreshInterval keeps a key well inside YNAB's 200 requests per hour.
+const MinRefreshInterval = FastRefreshInterval
+
+// rateLimitWindow is the rolling window YNAB counts requests over.
+const rateLimitWindow = time.Hour
+
+// lowRemainingDivisor: we start pacing requests once fewer than
+// 1/lowRemainingDivisor of the allowed requests are left.
+const lowRemainingDivisor = 10
+
+// RateLimit is YNAB's X-Rate-Limit header, e.g., "36/200":
+// the requests used, out of those allowed per rolling hour.
+type RateLimit struct {
+	Used  int
+	Limit int
+}
+
+func parseRateLimit(header string) (RateLimit, bool) {
+	usedStr, limitStr, ok := strings.Cut(header, "/")
+	if !ok {
+		return RateLimit{}, false
+	}
+	used, err := strconv.Atoi(strings.TrimSpace(usedStr))
+	if err != nil {
+		return RateLimit{}, false
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(limitStr))
+	if err != nil {
+		return RateLimit{}, false
+	}
+
+	return RateLimit{Used: used, Limit: limit}, true
+}
+
+// RetryAfter spreads the remaining requests over the rate limit window
+// once they are running low. It is zero while there is plenty left.
+func (r RateLimit) RetryAfter() time.Duration {
+	if r.Limit <= 0 {
+		return 0
+	}
+
+	remaining := r.Limit - r.Used
+	if remaining >= r.Limit/lowRemainingDivisor {
+		return 0
+	}
+	if remaining <= 0 {
+		return rateLimitWindow
+	}
+
+	return rateLimitWindow / time.Duration(remaining)
+}
+
+// FetchUnseenCountAndNextAccountId counts the unapproved transactions, and
+// finds the account of the first one and how much of the rate limit is used.
+func FetchUnseenCountAndNextAccountId(
+	ctx context.Context,
+	client *httpx.Client,
+	settings Settings,
+) (Result, error) {
 	if settings.BudgetUuid == "" {
-		return 0, errors.New("missing BudgetUuid")
+		return Result{}, inbox.NewError(inbox.CategoryConfig, "missing BudgetUuid")
 	}
 	if settings.PersonalAccessToken == "" {
-		return 0, errors.New("missing PersonalAccessToken")
+		return Result{}, inbox.NewError(inbox.CategoryConfig, "missing PersonalAccessToken")
 	}
 
-	return getUnseenCount(settings)
+	return getUnseenCount(ctx, client, settings)
 }
 
-func getUnseenCount(settings *Settings) (uint, error) {
+func getUnseenCount(ctx context.Context, client *httpx.Client, settings Settings) (Result, error) {
 	transactionsUrl := fmt.Sprintf(
 		"https://api.ynab.com/v1/budgets/%s/transactions?type=unapproved",
 		settings.BudgetUuid,
 	)
 
-	rawTransactions, err := makeRequest(transactionsUrl, settings.PersonalAccessToken)
+	rawTransactions, rateLimit, err := makeRequest(ctx, client, transactionsUrl, settings.PersonalAccessToken)
 	if err != nil {
-		return 0, fmt.Errorf("error while getting transactions: %w", err)
+		return Result{}, fmt.Errorf("error while getting transactions: %w", err)
 	}
 
 	type Transaction struct {
@@ -57,7 +116,7 @@ type TransactionsResponse struct {
 	transactions := &TransactionsResponse{}
 	err = json.Unmarshal(rawTransactions, transactions)
 	if err != nil {
-		return 0, fmt.Errorf("error while unmarshalling session response: %w", err)
+		return Result{}, fmt.Errorf("error while unmarshalling session response: %w", err)
 	}
 
 	result := slices.DeleteFunc(transactions.Data.Transactions, func(t Transaction) bool {
@@ -65,43 +124,34 @@ type TransactionsResponse struct {
 	})
 
 	if len(result) == 0 {
-		return 0, nil
+		return Result{RateLimit: rateLimit}, nil
 	}
-	settings.NextAccountId = result[0].AccountId
 
-	return uint(len(result)), nil
+	return Result{
+		Count:         uint(len(result)),
+		NextAccountId: result[0].AccountId,
+		RateLimit:     rateLimit,
+	}, nil
 }
 
-func makeRequest(url, bearer string) ([]byte, error) {
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("error while newing request: %w", err)
-	}
-
-	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Authorization", "Bearer "+bearer)
+func makeRequest(ctx context.Context, client *httpx.Client, url, bearer string) ([]byte, RateLimit, error) {
+	header := http.Header{}
+	header.Set("Accept", "application/json")
+	header.Set("Authorization", "Bearer "+bearer)
 
-	res, err := client.Do(req)
+	res, err := client.Get(ctx, url, header)
 	if err != nil {
-		return nil, fmt.Errorf("error while doing request: %w", err)
-	}
-
-	defer func(body io.ReadCloser) {
-		err := body.Close()
-		if err != nil {
-			log.Println("[ynab]", "error while closing body", err)
-		}
-	}(res.Body)
-
-	resBody, err := io.ReadAll(res.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error while reading body: %w", err)
+		return nil, RateLimit{}, err
 	}
 
 	// Keep an eye on rate limit
 	rateLimitResult := res.Header.Get("X-Rate-Limit")
 	log.Println("[ynab]", "rate limit", rateLimitResult)
 
-	return resBody, nil
+	rateLimit, ok := parseRateLimit(rateLimitResult)
+	if !ok {
+		log.Println("[ynab]", "unable to parse rate limit", rateLimitResult)
+	}
+
+	return res.Body, rateLimit, nil
 }
@@ -5,6 +5,7 @@
 	"encoding/json"
 	"time"
 
+	"ca.michaelabon.inboxes/internal/httpx"
 	"ca.michaelabon.inboxes/internal/inbox"
 	"github.com/samwho/streamdeck"
 )
@@ -13,13 +14,31 @@
 type Result struct {
 	Count         uint
 	NextAccountId string
+	RateLimit     RateLimit
+}
+
+// RetryAfter slows the poller down when the rate limit is running low.
+func (r Result) RetryAfter() time.Duration {
+	return r.RateLimit.RetryAfter()
 }
 
 // Service implements inbox.Service for YNAB (You Need A Budget).
-type Service struct{}
+// Use NewService, which sets up its HTTP client.
+type Service struct {
+	client *httpx.Client
+}
 
-// Compile-time check that Service implements the interface.
-var _ inbox.Service[*Settings, Result] = Service{}
+func NewService() Service {
+	return Service{client: httpx.New("[ynab]")}
+}
+
+// Compile-time check that Service and Result implement the interfaces.
+var (
+	_ inbox.Service[*Settings, Result]        = Service{}
+	_ inbox.MinRefreshIntervalProvider        = Service{}
+	_ inbox.GestureHandler[*Settings, Result] = Service{}
+	_ inbox.RetryAfterHint                    = Result{}
+)
 
 func (s Service) ActionUUID() string {
 	return "ca.michaelabon.streamdeck-inboxes.ynab.action"
@@ -29,6 +48,10 @@ func (s Service) RefreshInterval() time.Duration {
 	return FastRefreshInterval
 }
 
+func (s Service) MinRefreshInterval() time.Duration {
+	return MinRefreshInterval
+}
+
 func (s Service) LogPrefix() string {
 	return "[ynab]"
 }
@@ -43,16 +66,7 @@ func (s Service) ParseSettings(raw json.RawMessage) (*Settings, error) {
 }
 
 func (s Service) FetchResult(ctx context.Context, settings *Settings) (Result, error) {
-	// FetchUnseenCountAndNextAccountId modifies settings.NextAccountId as a side effect
-	count, err := FetchUnseenCountAndNextAccountId(settings)
-	if err != nil {
-		return Result{Count: 0}, err
-	}
-
-	return Result{
-		Count:         count,
-		NextAccountId: settings.NextAccountId,
-	}, nil
+	return FetchUnseenCountAndNextAccountId(ctx, s.client, *settings)
 }
 
 func (s Service) Render(
@@ -78,3 +92,24 @@ func (s Service) OpenURL(settings *Settings, result Result) string {
 
 	return url
 }
+
+// HandleGesture refreshes on a long press and opens the budget on a double press.
+func (s Service) HandleGesture(gesture inbox.Gesture, settings *Settings, result Result) inbox.KeyAction {
+	switch gesture {
+	case inbox.GestureLongPress:
+		return inbox.KeyAction{Kind: inbox.KeyActionRefresh}
+	case inbox.GestureDoublePress:
+		if settings.BudgetUuid == "" {
+			return inbox.KeyAction{Kind: inbox.KeyActionOpenURL}
+		}
+
+		return inbox.KeyAction{
+			Kind: inbox.KeyActionOpenAlternateURL,
+			URL:  "https://app.ynab.com/" + settings.BudgetUuid + "/budget",
+		}
+	case inbox.GesturePress:
+		return inbox.KeyAction{Kind: inbox.KeyActionOpenURL}
+	default:
+		return inbox.KeyAction{Kind: inbox.KeyActionOpenURL}
+	}
+}
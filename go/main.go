@@ -10,6 +10,7 @@
 	"ca.michaelabon.inboxes/internal/fastmail"
 	"ca.michaelabon.inboxes/internal/gitlab"
 	"ca.michaelabon.inboxes/internal/gmail"
+	"ca.michaelabon.inboxes/internal/imap"
 	"ca.michaelabon.inboxes/internal/inbox"
 	"ca.michaelabon.inboxes/internal/marvin"
 	"ca.michaelabon.inboxes/internal/todoist"
@@ -53,10 +54,11 @@ func run(ctx context.Context) error {
 }
 
 func setup(client *streamdeck.Client) {
-	inbox.Register(client, fastmail.Service{})
-	inbox.Register(client, gitlab.Service{})
-	inbox.Register(client, gmail.Service{})
-	inbox.Register(client, marvin.Service{})
-	inbox.Register(client, todoist.Service{})
-	inbox.Register(client, ynab.Service{})
+	inbox.Register(client, fastmail.NewService())
+	inbox.Register(client, gitlab.NewService())
+	inbox.Register(client, gmail.NewService())
+	inbox.Register(client, imap.Service{})
+	inbox.Register(client, marvin.NewService())
+	inbox.Register(client, todoist.NewService())
+	inbox.Register(client, ynab.NewService())
 }